@@ -5,6 +5,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
 	"os/signal"
 	"time"
@@ -30,10 +31,15 @@ func main() {
 
 	rootCmd, rootFlags := cmd.RootCommand(logger, BuildTag)
 	rootCmd.AddCommand(
+		cmd.ListCommand(logger, rootFlags),
 		cmd.LogsCommand(logger, rootFlags),
 		cmd.ExecCommand(logger, rootFlags),
+		cmd.CopyCommand(logger, rootFlags),
+		cmd.DumpCommand(logger, rootFlags),
 		cmd.ProxyCommand(logger, rootFlags),
+		cmd.KindKubeconfigCommand(logger, rootFlags),
 		cmd.KKPUserClusterCommand(logger, rootFlags),
+		cmd.KKPSeedCommand(logger, rootFlags),
 	)
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -49,6 +55,11 @@ func main() {
 	}()
 
 	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		var exitErr *cmd.ExitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
+
 		logger.Fatalf("Failed: %v", err)
 	}
 }
@@ -0,0 +1,338 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"go.xrstf.de/dj/pkg/util"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+type cpOptions struct {
+	Compress bool
+}
+
+func CopyCommand(logger logrus.FieldLogger, rootFlags *RootFlags) *cobra.Command {
+	opt := cpOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "cp SOURCE... DESTINATION",
+		Short: "Copy files and directories from and to the test container of a Prow job Pod",
+		Long: `Copy files and directories from and to the test container of a Prow job Pod.
+
+Remote paths are given as JOB:PATH, where JOB is anything that identifies a
+job (like the job ID or Pod name). Leave out the job (:PATH) to pick one
+interactively. Directories are always copied recursively. The last element of
+a remote source path can be a glob pattern (quote it to prevent your local
+shell from expanding it).
+
+Examples:
+  dj cp 1234567890:/logs/artifacts ./artifacts
+  dj cp '1234567890:/logs/artifacts/junit_*.xml' ./junit/
+  dj cp ./kubermatic-operator 1234567890:/usr/local/bin/`,
+		Args:         cobra.MinimumNArgs(2),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return cpAction(c.Context(), logger.WithField("namespace", rootFlags.Namespace), rootFlags, &opt, args)
+		},
+	}
+
+	pFlags := cmd.PersistentFlags()
+	pFlags.BoolVarP(&opt.Compress, "compress", "z", opt.Compress, "gzip-compress the data while transferring it (requires gzip support in the container's tar)")
+
+	return cmd
+}
+
+func cpAction(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, opt *cpOptions, args []string) error {
+	container, err := rootFlags.Container()
+	if err != nil {
+		return err
+	}
+
+	sources := args[:len(args)-1]
+	destination := args[len(args)-1]
+
+	if job, remotePath, isRemote := parseRemotePath(destination); isRemote {
+		for _, source := range sources {
+			if _, _, isRemote := parseRemotePath(source); isRemote {
+				return errors.New("either the sources or the destination must be local")
+			}
+		}
+
+		pod, err := waitForCopyPod(ctx, logger, rootFlags, container, job)
+		if err != nil {
+			return err
+		}
+
+		return copyToPod(ctx, logger.WithField("pod", pod.Name), rootFlags, opt, pod, container, sources, remotePath)
+	}
+
+	if len(sources) > 1 {
+		return errors.New("only a single remote source can be given (use a glob pattern to copy multiple files)")
+	}
+
+	job, remotePath, isRemote := parseRemotePath(sources[0])
+	if !isRemote {
+		return errors.New("either the sources or the destination must be a remote JOB:PATH")
+	}
+
+	pod, err := waitForCopyPod(ctx, logger, rootFlags, container, job)
+	if err != nil {
+		return err
+	}
+
+	return copyFromPod(ctx, logger.WithField("pod", pod.Name), rootFlags, opt, pod, container, remotePath, destination)
+}
+
+// parseRemotePath splits JOB:PATH arguments. Paths starting with "." or "/"
+// are always considered local.
+func parseRemotePath(arg string) (string, string, bool) {
+	if strings.HasPrefix(arg, ".") || strings.HasPrefix(arg, "/") {
+		return "", "", false
+	}
+
+	// use the last colon, as job URLs contain colons themselves
+	idx := strings.LastIndex(arg, ":")
+	if idx < 0 {
+		return "", "", false
+	}
+
+	remotePath := arg[idx+1:]
+	if remotePath == "" {
+		remotePath = "."
+	}
+
+	return arg[:idx], remotePath, true
+}
+
+func waitForCopyPod(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, container string, job string) (*corev1.Pod, error) {
+	ident, err := resolvePodIdentifier(ctx, logger, rootFlags, []string{job})
+	if err != nil {
+		return nil, err
+	}
+
+	// watch pods until we see the test container running
+	logger.WithFields(ident.Fields()).Info("Waiting for Pod to be running…")
+
+	pod, err := waitForPod(ctx, logger, rootFlags, ident, []string{container}, containerIsRunning(container), containerIsTerminated(container))
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for Pod: %w", err)
+	}
+	if pod == nil {
+		return nil, errors.New("Pod is terminated, cannot copy files")
+	}
+
+	return pod, nil
+}
+
+// tarFlags returns the flags for creating (c) or extracting (x) an archive.
+func (o *cpOptions) tarFlags(mode string) string {
+	if o.Compress {
+		return mode + "zf"
+	}
+
+	return mode + "f"
+}
+
+func copyFromPod(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, opt *cpOptions, pod *corev1.Pod, container string, remotePath string, destination string) error {
+	dir, pattern := path.Split(path.Clean(remotePath))
+	if dir == "" {
+		dir = "."
+	}
+
+	// "/" or "." mean copying the contents of a directory
+	isContents := pattern == "" || pattern == "."
+	if isContents {
+		dir = path.Clean(remotePath)
+		pattern = "."
+	}
+
+	// only glob patterns are left unquoted, so that the shell expands them;
+	// the directory is always quoted and an empty IFS prevents the pattern
+	// from being split at whitespace
+	script := fmt.Sprintf(`cd "$1" && tar %s - -- "$2"`, opt.tarFlags("c"))
+
+	isGlob := strings.ContainsAny(pattern, "*?[")
+	if isGlob {
+		script = fmt.Sprintf(`cd "$1" && IFS= && tar %s - -- $2`, opt.tarFlags("c"))
+	}
+
+	// multiple files are always copied into the destination directory,
+	// a single file or directory can be renamed during the copy
+	targetDir := destination
+	rename := ""
+
+	if isGlob || isContents {
+		if err := os.MkdirAll(destination, 0755); err != nil {
+			return err
+		}
+	} else if info, err := os.Stat(destination); (err != nil || !info.IsDir()) && !strings.HasSuffix(destination, "/") {
+		targetDir = filepath.Dir(destination)
+		rename = filepath.Base(destination)
+	}
+
+	logger.WithField("source", remotePath).WithField("destination", destination).Info("Copying files…")
+
+	var stderr bytes.Buffer
+
+	reader, writer := io.Pipe()
+	remoteErr := make(chan error, 1)
+
+	go func() {
+		command := []string{"sh", "-c", script, "dj-cp", dir, pattern}
+		err := util.RunCommandWithStreams(ctx, rootFlags.ClientSet, rootFlags.RESTConfig, pod, container, command, nil, writer, &stderr)
+		writer.CloseWithError(err)
+		remoteErr <- err
+	}()
+
+	var (
+		archive io.Reader = reader
+		err     error
+	)
+
+	if opt.Compress {
+		var gz *gzip.Reader
+
+		gz, err = gzip.NewReader(reader)
+		if err == nil {
+			archive = gz
+		}
+	}
+
+	if err == nil {
+		err = util.ExtractTar(archive, targetDir, rename)
+	}
+
+	// tar streams can contain padding after the end of the archive
+	if err == nil {
+		_, err = io.Copy(io.Discard, reader)
+	}
+
+	// stop the remote command if extracting failed early
+	reader.CloseWithError(err)
+
+	if rErr := <-remoteErr; rErr != nil {
+		return fmt.Errorf("failed to copy from Pod: %w: %s", rErr, strings.TrimSpace(stderr.String()))
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to extract files: %w", err)
+	}
+
+	logger.Info("Files copied.")
+
+	return nil
+}
+
+func copyToPod(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, opt *cpOptions, pod *corev1.Pod, container string, sources []string, remotePath string) error {
+	var paths []string
+
+	for _, source := range sources {
+		matches, err := filepath.Glob(source)
+		if err != nil {
+			return fmt.Errorf("invalid source %q: %w", source, err)
+		}
+
+		if len(matches) == 0 {
+			return fmt.Errorf("%s: no such file or directory", source)
+		}
+
+		paths = append(paths, matches...)
+	}
+
+	isDir, err := remoteIsDirectory(ctx, rootFlags, pod, container, remotePath)
+	if err != nil {
+		return err
+	}
+
+	// multiple files are always copied into the destination directory,
+	// a single file or directory can be renamed during the copy
+	targetDir := remotePath
+	tarSources := []util.TarSource{}
+
+	if !isDir && !strings.HasSuffix(remotePath, "/") && len(paths) == 1 {
+		targetDir = path.Dir(remotePath)
+		tarSources = append(tarSources, util.TarSource{Path: paths[0], Name: path.Base(remotePath)})
+	} else {
+		for _, p := range paths {
+			tarSources = append(tarSources, util.TarSource{Path: p, Name: filepath.Base(p)})
+		}
+	}
+
+	logger.WithField("source", strings.Join(sources, ", ")).WithField("destination", remotePath).Info("Copying files…")
+
+	reader, writer := io.Pipe()
+	localErr := make(chan error, 1)
+
+	go func() {
+		var (
+			out io.Writer = writer
+			gz  *gzip.Writer
+		)
+
+		if opt.Compress {
+			gz = gzip.NewWriter(writer)
+			out = gz
+		}
+
+		err := util.WriteTar(out, tarSources)
+		if err == nil && gz != nil {
+			err = gz.Close()
+		}
+
+		writer.CloseWithError(err)
+		localErr <- err
+	}()
+
+	var stderr bytes.Buffer
+
+	script := fmt.Sprintf(`mkdir -p "$1" && tar %s - -C "$1"`, opt.tarFlags("x"))
+	command := []string{"sh", "-c", script, "dj-cp", targetDir}
+
+	err = util.RunCommandWithStreams(ctx, rootFlags.ClientSet, rootFlags.RESTConfig, pod, container, command, reader, io.Discard, &stderr)
+
+	// stop writing the archive if the remote command failed early
+	reader.CloseWithError(err)
+
+	lErr := <-localErr
+
+	// a failing remote tar also makes writing the archive fail, so the remote
+	// error is the more helpful one
+	if err != nil {
+		return fmt.Errorf("failed to copy to Pod: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	if lErr != nil {
+		return fmt.Errorf("failed to archive files: %w", lErr)
+	}
+
+	logger.Info("Files copied.")
+
+	return nil
+}
+
+func remoteIsDirectory(ctx context.Context, rootFlags *RootFlags, pod *corev1.Pod, container string, remotePath string) (bool, error) {
+	command := []string{"sh", "-c", `if [ -d "$1" ]; then echo dir; fi`, "dj-cp", remotePath}
+
+	output, err := util.RunCommand(ctx, rootFlags.ClientSet, rootFlags.RESTConfig, pod, container, command, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to check destination: %w", err)
+	}
+
+	return strings.TrimSpace(output) == "dir", nil
+}
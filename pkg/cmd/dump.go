@@ -0,0 +1,484 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"go.xrstf.de/dj/pkg/kind"
+	"go.xrstf.de/dj/pkg/kkp"
+	"go.xrstf.de/dj/pkg/prow"
+	"go.xrstf.de/dj/pkg/script"
+	"go.xrstf.de/dj/pkg/util"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"sigs.k8s.io/yaml"
+)
+
+type dumpOptions struct {
+	Output       string
+	Only         []string
+	Skip         []string
+	List         bool
+	Timeout      time.Duration
+	KindCluster  string
+	KKPNamespace string
+}
+
+func DumpCommand(logger logrus.FieldLogger, rootFlags *RootFlags) *cobra.Command {
+	opt := dumpOptions{
+		Timeout:      2 * time.Minute,
+		KKPNamespace: kkp.DefaultNamespace,
+	}
+
+	cmd := &cobra.Command{
+		Use:          "dump [ PROWJOB_ID | PROWJOB_POD_NAME ]",
+		Short:        "Collect diagnostics from the test container and its kind cluster into a debug bundle",
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return dumpAction(c.Context(), logger.WithField("namespace", rootFlags.Namespace), rootFlags, &opt, args)
+		},
+	}
+
+	pFlags := cmd.PersistentFlags()
+	pFlags.StringVarP(&opt.Output, "output", "o", opt.Output, "filename of the bundle (defaults to <buildid>-dump.tar.gz, the bundle is gzip-compressed unless the filename ends with .tar)")
+	pFlags.StringSliceVar(&opt.Only, "only", opt.Only, "only run these collectors (see --list)")
+	pFlags.StringSliceVar(&opt.Skip, "skip", opt.Skip, "do not run these collectors (see --list)")
+	pFlags.BoolVar(&opt.List, "list", opt.List, "list all available collectors and exit")
+	pFlags.DurationVar(&opt.Timeout, "collector-timeout", opt.Timeout, "maximum time a single collector may take")
+	pFlags.StringVar(&opt.KindCluster, "kind-cluster", opt.KindCluster, "name of the kind cluster to use (required if the job runs multiple kind clusters)")
+	pFlags.StringVar(&opt.KKPNamespace, "kkp-namespace", opt.KKPNamespace, "namespace KKP is installed into")
+
+	return cmd
+}
+
+// dumpCollector gathers a single piece of diagnostic information.
+type dumpCollector struct {
+	Name        string
+	Filename    string
+	Description string
+	Collect     func(ctx context.Context, d *dumper) ([]byte, error)
+}
+
+var dumpCollectors = []dumpCollector{
+	{
+		Name:        "pod",
+		Filename:    "pod.yaml",
+		Description: "the Prow job Pod",
+		Collect:     dumpPod,
+	},
+	{
+		Name:        "pod-events",
+		Filename:    "pod-events.txt",
+		Description: "Kubernetes events for the Prow job Pod",
+		Collect:     dumpPodEvents,
+	},
+	{
+		Name:        "processes",
+		Filename:    "container/processes.txt",
+		Description: "processes running in the test container",
+		Collect:     containerScript(script.DumpProcesses),
+	},
+	{
+		Name:        "disk",
+		Filename:    "container/disk.txt",
+		Description: "disk usage in the test container",
+		Collect:     containerScript(script.DumpDisk),
+	},
+	{
+		Name:        "kind-clusters",
+		Filename:    "kind/clusters.txt",
+		Description: "kind clusters in the test container",
+		Collect:     containerScript(script.DumpKindClusters),
+	},
+	{
+		Name:        "kind-logs",
+		Filename:    "kind/logs.tar.gz",
+		Description: "output of kind export logs",
+		Collect:     kindScript(script.DumpKindLogs),
+	},
+	{
+		Name:        "kind-nodes",
+		Filename:    "kind/nodes.yaml",
+		Description: "nodes of the kind cluster",
+		Collect:     kindScript(script.DumpKindNodes),
+	},
+	{
+		Name:        "kind-resources",
+		Filename:    "kind/resources.txt",
+		Description: "kubectl get all --all-namespaces in the kind cluster",
+		Collect:     kindScript(script.DumpKindResources),
+	},
+	{
+		Name:        "kind-events",
+		Filename:    "kind/events.txt",
+		Description: "events in the kind cluster",
+		Collect:     kindScript(script.DumpKindEvents),
+	},
+	{
+		Name:        "kkp-objects",
+		Filename:    "kkp/objects.yaml",
+		Description: "KKP KubermaticConfigurations, Seeds and Clusters in the kind cluster",
+		Collect:     kindScript(script.DumpKKPObjects),
+	},
+	{
+		Name:        "kkp-logs",
+		Filename:    "kkp/controller-logs.txt",
+		Description: "logs of all Pods in the KKP namespace (see --kkp-namespace) of the kind cluster",
+		Collect:     kindScript(script.DumpKKPLogs),
+	},
+}
+
+// dumpIndex is written into every bundle and describes its contents.
+type dumpIndex struct {
+	Pod        string           `json:"pod"`
+	Namespace  string           `json:"namespace"`
+	BuildID    string           `json:"buildID,omitempty"`
+	JobName    string           `json:"jobName,omitempty"`
+	Created    time.Time        `json:"created"`
+	Collectors []dumpIndexEntry `json:"collectors"`
+}
+
+type dumpIndexEntry struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Filename    string `json:"filename,omitempty"`
+	Duration    string `json:"duration"`
+	Error       string `json:"error,omitempty"`
+}
+
+type dumper struct {
+	logger    logrus.FieldLogger
+	rootFlags *RootFlags
+	pod       *corev1.Pod
+	container string
+	// false if the test container has already terminated
+	running bool
+	// determined when the first kind collector runs
+	kindCluster  string
+	kkpNamespace string
+}
+
+func dumpAction(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, opt *dumpOptions, args []string) (err error) {
+	if opt.List {
+		return listDumpCollectors()
+	}
+
+	collectors, err := opt.collectors()
+	if err != nil {
+		return err
+	}
+
+	container, err := rootFlags.Container()
+	if err != nil {
+		return err
+	}
+
+	ident, err := resolvePodIdentifier(ctx, logger, rootFlags, args)
+	if err != nil {
+		return err
+	}
+
+	// a failed job's Pod can still be inspected, just not exec'ed into
+	logger.WithFields(ident.Fields()).Info("Waiting for Pod to be running…")
+
+	pod, err := waitForPod(ctx, logger, rootFlags, ident, []string{container}, logsAvailable([]string{container}), nil)
+	if err != nil {
+		return fmt.Errorf("failed to wait for Pod: %w", err)
+	}
+
+	logger = logger.WithField("pod", pod.Name)
+
+	d := &dumper{
+		logger:       logger,
+		rootFlags:    rootFlags,
+		pod:          pod,
+		container:    container,
+		running:      containerIsRunning(container)(pod),
+		kindCluster:  opt.KindCluster,
+		kkpNamespace: opt.KKPNamespace,
+	}
+
+	if !d.running {
+		logger.Warn("Test container is not running anymore, only Pod information can be collected.")
+	}
+
+	filename := opt.Output
+	if filename == "" {
+		filename = fmt.Sprintf("%s-dump.tar.gz", prow.PodName(pod))
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+
+		// do not leave incomplete bundles behind
+		if err != nil {
+			os.Remove(filename)
+		}
+	}()
+
+	var (
+		out io.Writer = f
+		gz  *gzip.Writer
+	)
+
+	if !strings.HasSuffix(filename, ".tar") {
+		gz = gzip.NewWriter(f)
+		out = gz
+	}
+
+	tw := tar.NewWriter(out)
+
+	// all files are placed in a directory named after the bundle
+	root := path.Base(filename)
+	for _, ext := range []string{".gz", ".tgz", ".tar"} {
+		root = strings.TrimSuffix(root, ext)
+	}
+
+	index := dumpIndex{
+		Pod:       pod.Name,
+		Namespace: pod.Namespace,
+		BuildID:   pod.Labels[prow.BuildIDLabel],
+		JobName:   pod.Annotations[prow.JobNameAnnotation],
+		Created:   time.Now().UTC(),
+	}
+
+	logger.WithField("output", filename).Info("Collecting diagnostics…")
+
+	for _, collector := range collectors {
+		cLogger := logger.WithField("collector", collector.Name)
+		cLogger.Info("Running collector…")
+
+		start := time.Now()
+		entry := dumpIndexEntry{
+			Name:        collector.Name,
+			Description: collector.Description,
+		}
+
+		collectCtx, cancel := context.WithTimeout(ctx, opt.Timeout)
+		data, collectErr := collector.Collect(collectCtx, d)
+		cancel()
+
+		entry.Duration = time.Since(start).Round(time.Millisecond).String()
+
+		if collectErr != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			cLogger.WithError(collectErr).Warn("Collector failed.")
+			entry.Error = collectErr.Error()
+
+			// an empty message must not look like success
+			if entry.Error == "" {
+				entry.Error = "unknown error"
+			}
+		}
+
+		// failed collectors might still have produced partial output
+		if len(data) > 0 {
+			if err := addTarFile(tw, path.Join(root, collector.Filename), data); err != nil {
+				return fmt.Errorf("failed to write bundle: %w", err)
+			}
+
+			entry.Filename = collector.Filename
+		}
+
+		index.Collectors = append(index.Collectors, entry)
+	}
+
+	encoded, err := yaml.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("failed to encode index: %w", err)
+	}
+
+	if err := addTarFile(tw, path.Join(root, "index.yaml"), encoded); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("failed to write bundle: %w", err)
+		}
+	}
+
+	logger.WithField("output", filename).Info("Debug bundle written.")
+
+	return nil
+}
+
+func (o *dumpOptions) collectors() ([]dumpCollector, error) {
+	names := []string{}
+	for _, collector := range dumpCollectors {
+		names = append(names, collector.Name)
+	}
+
+	for _, name := range append(slices.Clone(o.Only), o.Skip...) {
+		if !slices.Contains(names, name) {
+			return nil, fmt.Errorf("unknown collector %q (known collectors are %s)", name, strings.Join(names, ", "))
+		}
+	}
+
+	var result []dumpCollector
+	for _, collector := range dumpCollectors {
+		if len(o.Only) > 0 && !slices.Contains(o.Only, collector.Name) {
+			continue
+		}
+
+		if slices.Contains(o.Skip, collector.Name) {
+			continue
+		}
+
+		result = append(result, collector)
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("no collectors selected")
+	}
+
+	return result, nil
+}
+
+func listDumpCollectors() error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tFILE\tDESCRIPTION")
+
+	for _, collector := range dumpCollectors {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", collector.Name, collector.Filename, collector.Description)
+	}
+
+	return w.Flush()
+}
+
+func addTarFile(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err := tw.Write(data)
+
+	return err
+}
+
+func dumpPod(_ context.Context, d *dumper) ([]byte, error) {
+	pod := d.pod.DeepCopy()
+	pod.ManagedFields = nil
+
+	return yaml.Marshal(pod)
+}
+
+func dumpPodEvents(ctx context.Context, d *dumper) ([]byte, error) {
+	selector := fields.OneTermEqualSelector("involvedObject.name", d.pod.Name).String()
+
+	events, err := d.rootFlags.ClientSet.CoreV1().Events(d.pod.Namespace).List(ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	var out strings.Builder
+
+	w := tabwriter.NewWriter(&out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "LAST SEEN\tTYPE\tREASON\tCOUNT\tMESSAGE")
+
+	for _, event := range events.Items {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", event.LastTimestamp.UTC().Format(time.RFC3339), event.Type, event.Reason, event.Count, event.Message)
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return []byte(out.String()), nil
+}
+
+func (d *dumper) run(ctx context.Context, s *script.Script, params script.Params) ([]byte, error) {
+	if !d.running {
+		return nil, errors.New("test container is not running")
+	}
+
+	command, err := s.Command(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	// unlike util.RunCommand, keep the exec error (which includes the exit
+	// code), as failing commands do not necessarily output anything
+	err = util.RunCommandWithStreams(ctx, d.rootFlags.ClientSet, d.rootFlags.RESTConfig, d.pod, d.container, command, nil, &stdout, &stderr)
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+	}
+
+	return stdout.Bytes(), err
+}
+
+// containerScript returns a collector that runs a script in the test container.
+func containerScript(s *script.Script) func(ctx context.Context, d *dumper) ([]byte, error) {
+	return func(ctx context.Context, d *dumper) ([]byte, error) {
+		return d.run(ctx, s, nil)
+	}
+}
+
+// kindScript returns a collector that runs a kind script (see script.DumpKindLogs)
+// in the test container.
+func kindScript(s *script.Script) func(ctx context.Context, d *dumper) ([]byte, error) {
+	return func(ctx context.Context, d *dumper) ([]byte, error) {
+		// unlike other commands, do not wait for a kind cluster to appear
+		if d.kindCluster == "" && d.running {
+			provider := kind.NewProvider(d.logger, d.rootFlags.ClientSet, d.rootFlags.RESTConfig, d.pod, d.container)
+
+			clusters, err := provider.Clusters(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			d.kindCluster, err = kind.ChooseCluster(clusters)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return d.run(ctx, s, script.Params{
+			"ClusterName":  d.kindCluster,
+			"KKPNamespace": d.kkpNamespace,
+		})
+	}
+}
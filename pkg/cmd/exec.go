@@ -7,42 +7,74 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 
+	"go.xrstf.de/dj/pkg/picker"
 	"go.xrstf.de/dj/pkg/prow"
 	"go.xrstf.de/dj/pkg/util"
 
 	corev1 "k8s.io/api/core/v1"
+	utilexec "k8s.io/client-go/util/exec"
 )
 
+type execOptions struct {
+	Stdin bool
+	TTY   bool
+}
+
 func ExecCommand(logger logrus.FieldLogger, rootFlags *RootFlags) *cobra.Command {
+	opt := execOptions{}
+
 	cmd := &cobra.Command{
-		Use:          "exec ( PROWJOB_ID | PROWJOB_POD_NAME ) [ COMMAND = bash ]",
+		Use:          "exec [ PROWJOB_ID | PROWJOB_POD_NAME ] [ COMMAND = bash ]",
 		Short:        "Execute a command in a Prow job Pod",
 		SilenceUsage: true,
 		RunE: func(c *cobra.Command, args []string) error {
-			return execAction(c.Context(), logger.WithField("namespace", rootFlags.Namespace), rootFlags, args)
+			// "dj exec -- ls" means no job was given
+			if c.ArgsLenAtDash() == 0 {
+				args = append([]string{""}, args...)
+			}
+
+			if !c.Flags().Changed("stdin") && !c.Flags().Changed("tty") {
+				opt.detectStreams()
+			}
+
+			return execAction(c.Context(), logger.WithField("namespace", rootFlags.Namespace), rootFlags, &opt, args)
 		},
 	}
 
+	pFlags := cmd.PersistentFlags()
+	pFlags.BoolVarP(&opt.Stdin, "stdin", "i", opt.Stdin, "pass stdin to the command (auto-detected if neither --stdin nor --tty are given)")
+	pFlags.BoolVarP(&opt.TTY, "tty", "t", opt.TTY, "allocate a TTY for the command, implies --stdin (auto-detected if neither --stdin nor --tty are given)")
+
 	return cmd
 }
 
-func execAction(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, args []string) error {
-	if len(args) < 1 {
-		return errors.New("no job ID or Pod name given")
+// detectStreams behaves like "kubectl exec -it" when dj runs in a terminal and
+// like "kubectl exec -i" when input is piped into dj.
+func (o *execOptions) detectStreams() {
+	o.TTY = picker.IsTerminal(os.Stdin, os.Stdout)
+	o.Stdin = o.TTY || !term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+func execAction(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, opt *execOptions, args []string) error {
+	if opt.TTY && !term.IsTerminal(int(os.Stdin.Fd())) {
+		logger.Warn("Unable to use a TTY because stdin is not a terminal.")
+		opt.TTY = false
 	}
 
-	// default to running a shell
-	if len(args) < 2 {
-		args = append(args, "bash")
+	container, err := rootFlags.Container()
+	if err != nil {
+		return err
 	}
 
-	ident, err := prow.ParsePodIdentifier(args[0])
+	ident, err := resolvePodIdentifier(ctx, logger, rootFlags, args)
 	if err != nil {
 		return err
 	}
@@ -50,48 +82,76 @@ func execAction(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootF
 	// watch pods until we see the test container running
 	logger.WithFields(ident.Fields()).Info("Waiting for Pod to be running…")
 
-	pod, err := ident.WaitForPod(ctx, rootFlags.ClientSet, rootFlags.Namespace, podIsRunninng, podIsTerminated)
+	pod, err := waitForPod(ctx, logger, rootFlags, ident, []string{container}, containerIsRunning(container), containerIsTerminated(container))
 	if err != nil {
-		return fmt.Errorf("failed to watch Pods: %w", err)
+		return fmt.Errorf("failed to wait for Pod: %w", err)
 	}
 	if pod == nil {
 		return errors.New("Pod is terminated, cannot execute commands")
 	}
 
-	command := args[1:]
+	// default to running a shell
+	command := []string{"bash"}
+	if len(args) > 1 {
+		command = args[1:]
+	}
 
 	logger = logger.WithField("pod", pod.Name)
 	logger.WithField("cmd", strings.Join(command, " ")).Info("Running command")
 
-	return util.RunCommandWithTTY(ctx, rootFlags.ClientSet, rootFlags.RESTConfig, pod, prow.TestContainerName, command, os.Stdin, os.Stdout, os.Stderr)
-}
+	if opt.TTY {
+		err = util.RunCommandWithTTY(ctx, rootFlags.ClientSet, rootFlags.RESTConfig, pod, container, command, os.Stdin, os.Stdout, os.Stderr)
+	} else {
+		// without a TTY, stdout and stderr are kept separate and the output
+		// is passed through unmodified
+		var stdin io.Reader
+		if opt.Stdin {
+			stdin = os.Stdin
+		}
 
-func podIsRunninng(pod *corev1.Pod) bool {
-	if pod == nil {
-		return false
+		err = util.RunCommandWithStreams(ctx, rootFlags.ClientSet, rootFlags.RESTConfig, pod, container, command, stdin, os.Stdout, os.Stderr)
 	}
 
-	for _, status := range pod.Status.ContainerStatuses {
-		if status.Name == prow.TestContainerName {
-			return status.State.Running != nil
-		}
+	// pass the exit code of the remote command through to the caller
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Exited() {
+		logger.WithField("code", exitErr.ExitStatus()).Debug("Command exited with non-zero exit code")
+		return &ExitCodeError{Code: exitErr.ExitStatus()}
 	}
 
-	// container has no status yet
-	return false
+	return err
 }
 
-func podIsTerminated(pod *corev1.Pod) bool {
-	if pod == nil {
+func containerIsRunning(container string) prow.PodCheckerFunc {
+	return func(pod *corev1.Pod) bool {
+		if pod == nil {
+			return false
+		}
+
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == container {
+				return status.State.Running != nil
+			}
+		}
+
+		// container has no status yet
 		return false
 	}
+}
 
-	for _, status := range pod.Status.ContainerStatuses {
-		if status.Name == prow.TestContainerName {
-			return status.State.Terminated != nil
+func containerIsTerminated(container string) prow.PodCheckerFunc {
+	return func(pod *corev1.Pod) bool {
+		if pod == nil {
+			return false
 		}
-	}
 
-	// container has no status yet
-	return false
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == container {
+				return status.State.Terminated != nil
+			}
+		}
+
+		// container has no status yet
+		return false
+	}
 }
@@ -0,0 +1,300 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"go.xrstf.de/dj/pkg/picker"
+	"go.xrstf.de/dj/pkg/prow"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// resolvePodIdentifier parses the first argument and, if it does not point to
+// a single job run already, resolves it to the most recent run. If no argument
+// is given and dj runs in a terminal, the user can interactively pick a job.
+// If the job's Pod does not exist (anymore), the job's ProwJob is consulted to
+// prevent waiting for a Pod that will never appear. If multiple Pods match the
+// job, one of them is selected (see selectPod).
+func resolvePodIdentifier(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, args []string) (*prow.PodIdentifier, error) {
+	if len(args) == 0 || args[0] == "" {
+		if !picker.IsTerminal(os.Stdin, os.Stderr) {
+			return nil, errors.New("no job ID or Pod name given")
+		}
+
+		ident, err := pickPodIdentifier(ctx, rootFlags)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := selectPod(ctx, logger, rootFlags, ident); err != nil {
+			return nil, err
+		}
+
+		return ident, nil
+	}
+
+	ident, err := prow.ParsePodIdentifier(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if !ident.IsUnique() {
+		logger.WithFields(ident.Fields()).Info("Finding most recent job run…")
+
+		err := ident.Resolve(ctx, logger, rootFlags.ClientSet, rootFlags.Namespace)
+		if err != nil && !errors.Is(err, prow.ErrNoMatchingPod) {
+			return nil, err
+		}
+	}
+
+	if err := checkProwJob(ctx, logger, rootFlags, ident); err != nil {
+		return nil, err
+	}
+
+	if err := selectPod(ctx, logger, rootFlags, ident); err != nil {
+		return nil, err
+	}
+
+	return ident, nil
+}
+
+// selectPod narrows down the identifier to a single Pod, in case multiple Pods
+// match it (e.g. because a job was retried). By default the newest Pod is used,
+// but users can choose via --pod, --pod-index or --select-pod.
+func selectPod(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, ident *prow.PodIdentifier) error {
+	pods, err := ident.FindPods(ctx, rootFlags.ClientSet, rootFlags.Namespace)
+	if err != nil {
+		return err
+	}
+
+	for idx, pod := range pods {
+		logger.WithFields(logrus.Fields{
+			"index":   idx,
+			"pod":     pod.Name,
+			"created": pod.CreationTimestamp.Format(time.RFC3339),
+			"node":    pod.Spec.NodeName,
+			"phase":   pod.Status.Phase,
+		}).Debug("Candidate Pod")
+	}
+
+	if rootFlags.PodName != "" {
+		for _, pod := range pods {
+			if pod.Name == rootFlags.PodName {
+				ident.PodName = pod.Name
+				return nil
+			}
+		}
+
+		return fmt.Errorf("Pod %q does not match %s", rootFlags.PodName, ident)
+	}
+
+	if rootFlags.PodIndex < 0 {
+		return fmt.Errorf("invalid --pod-index %d", rootFlags.PodIndex)
+	}
+
+	// If no Pods exist yet, the first one that appears will be used; if
+	// exactly one exists, using it is the same as not narrowing the identifier
+	// down, except that it protects against stale Pods reappearing in the watch.
+	if len(pods) == 0 {
+		if rootFlags.PodIndex > 0 {
+			return fmt.Errorf("--pod-index %d given, but no Pod exists yet", rootFlags.PodIndex)
+		}
+
+		return nil
+	}
+
+	index := rootFlags.PodIndex
+
+	if rootFlags.SelectPod && len(pods) > 1 {
+		if !picker.IsTerminal(os.Stdin, os.Stderr) {
+			return errors.New("--select-pod requires a terminal")
+		}
+
+		index, err = pickPod(pods)
+		if err != nil {
+			return err
+		}
+	}
+
+	if index >= len(pods) {
+		return fmt.Errorf("--pod-index %d given, but only %d Pod(s) match", index, len(pods))
+	}
+
+	if len(pods) > 1 && !rootFlags.SelectPod && rootFlags.PodIndex == 0 {
+		logger.Warnf("%d Pods match %s, using %s (use --pod, --pod-index or --select-pod to choose a different one).", len(pods), ident, pods[index].Name)
+	}
+
+	ident.PodName = pods[index].Name
+
+	return nil
+}
+
+func pickPod(pods []corev1.Pod) (int, error) {
+	var buf bytes.Buffer
+
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "POD\tAGE\tSTATE\tNODE")
+
+	for _, pod := range pods {
+		state, _ := prow.TestContainerState(&pod)
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			pod.Name,
+			duration.HumanDuration(time.Since(pod.CreationTimestamp.Time)),
+			state,
+			orDash(pod.Spec.NodeName),
+		)
+	}
+
+	if err := w.Flush(); err != nil {
+		return -1, err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+
+	return picker.Pick(os.Stdin, os.Stderr, "Select Pod:", lines[0], lines[1:])
+}
+
+func checkProwJob(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, ident *prow.PodIdentifier) error {
+	pods, err := ident.FindPods(ctx, rootFlags.ClientSet, rootFlags.Namespace)
+	if err != nil {
+		return err
+	}
+
+	// the cheapest way to find the ProwJob is by its name, which is stored on the Pod
+	lookup := ident
+	if len(pods) > 0 && ident.JobID == "" {
+		lookup = &prow.PodIdentifier{JobID: pods[0].Labels[prow.JobIDLabel]}
+	}
+
+	pj, err := lookup.FindProwJob(ctx, rootFlags.DynamicClient, rootFlags.ProwJobNamespace)
+	if err != nil {
+		if !prow.IsProwJobsUnavailable(err) {
+			return fmt.Errorf("failed to find ProwJob: %w", err)
+		}
+
+		logger.WithError(err).Debug("Cannot access ProwJobs, relying on Pods only.")
+
+		// without ProwJobs, dj can still wait for a specific Pod to appear,
+		// but cannot resolve a job name
+		if len(pods) == 0 && !ident.IsUnique() {
+			return fmt.Errorf("%w %s", prow.ErrNoMatchingPod, ident)
+		}
+
+		return nil
+	}
+
+	if pj == nil {
+		if len(pods) == 0 {
+			return fmt.Errorf("%w %s and no matching ProwJob exists either", prow.ErrNoMatchingPod, ident)
+		}
+
+		return nil
+	}
+
+	logger.WithFields(logrus.Fields{
+		"prowjob": pj.Name,
+		"state":   pj.Status.State,
+		"refs":    pj.RefsString(),
+		"url":     pj.Status.URL,
+	}).Info("Found ProwJob.")
+
+	if len(pods) > 0 {
+		return nil
+	}
+
+	switch {
+	case pj.Status.State == prow.ProwJobStateAborted:
+		return fmt.Errorf("job was aborted, see %s", pj.Status.URL)
+
+	case pj.IsFinished():
+		return fmt.Errorf("job has already finished (%s) and its Pod was deleted, see %s", pj.Status.State, pj.Status.URL)
+	}
+
+	// the Pod does not exist yet, so make sure to wait for the correct one
+	ident.JobID = pj.Name
+	ident.BuildID = pj.Status.BuildID
+
+	logger.Infof("Job is %s, waiting for its Pod to be created…", pj.Status.State)
+
+	return nil
+}
+
+// waitForPod waits for the Pod identified by ident to satisfy validPod,
+// honoring the global wait timeout.
+func waitForPod(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, ident *prow.PodIdentifier, containers []string, validPod prow.PodCheckerFunc, giveUp prow.PodCheckerFunc) (*corev1.Pod, error) {
+	ctx, cancel := withWaitTimeout(ctx, rootFlags)
+	defer cancel()
+
+	pod, err := ident.WaitForPod(ctx, logger, rootFlags.ClientSet, rootFlags.Namespace, containers, validPod, giveUp)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, fmt.Errorf("Pod did not become ready within %v", rootFlags.WaitTimeout)
+	}
+
+	return pod, err
+}
+
+func pickPodIdentifier(ctx context.Context, rootFlags *RootFlags) (*prow.PodIdentifier, error) {
+	jobs, err := prow.ListJobs(ctx, rootFlags.ClientSet, rootFlags.Namespace, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// only offer jobs that are still interesting
+	active := []prow.Job{}
+	for _, job := range jobs {
+		if !jobHasState(job, "finished") {
+			active = append(active, job)
+		}
+	}
+
+	if len(active) == 0 {
+		return nil, fmt.Errorf("no job ID or Pod name given and no Prow jobs are running in namespace %q", rootFlags.Namespace)
+	}
+
+	var buf bytes.Buffer
+
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tPR\tAGE\tSTATE\tBUILD")
+
+	for _, job := range active {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			job.Name,
+			orDash(jobPull(job)),
+			duration.HumanDuration(time.Since(job.Created)),
+			job.State,
+			job.BuildID,
+		)
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+
+	selected, err := picker.Pick(os.Stdin, os.Stderr, "Select job:", lines[0], lines[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	job := active[selected]
+
+	return &prow.PodIdentifier{
+		BuildID: job.BuildID,
+		JobID:   job.JobID,
+	}, nil
+}
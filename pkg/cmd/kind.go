@@ -0,0 +1,114 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"go.xrstf.de/dj/pkg/kind"
+	"go.xrstf.de/dj/pkg/kkp"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// connectKindCluster waits for the given kind cluster to be ready and forwards
+// its API server to the given local port (0 picks a random free port). If no
+// name is given, the test container must contain exactly one kind cluster.
+// The returned connection must be closed by the caller.
+func connectKindCluster(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, pod *corev1.Pod, container string, name string, localPort int) (*kind.Connection, error) {
+	provider := kind.NewProvider(logger, rootFlags.ClientSet, rootFlags.RESTConfig, pod, container)
+
+	logger.Info("Waiting for Kind cluster to be available…")
+
+	// the port-forwarding must outlive the wait timeout
+	waitCtx, cancel := withWaitTimeout(ctx, rootFlags)
+	defer cancel()
+
+	if name == "" {
+		clusters, err := provider.WaitForClusters(waitCtx)
+		if err != nil {
+			return nil, kindWaitError(err, rootFlags.WaitTimeout)
+		}
+
+		name, err = kind.ChooseCluster(clusters)
+		if err != nil {
+			return nil, err
+		}
+	} else if err := provider.WaitForCluster(waitCtx, name); err != nil {
+		return nil, kindWaitError(err, rootFlags.WaitTimeout)
+	}
+
+	logger = logger.WithField("kindcluster", name)
+
+	kubeconfig, err := provider.Kubeconfig(waitCtx, name)
+	if err != nil {
+		return nil, kindWaitError(err, rootFlags.WaitTimeout)
+	}
+
+	conn, err := provider.Connect(ctx, name, kubeconfig, localPort)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.WaitForReady(waitCtx); err != nil {
+		conn.Close()
+		return nil, kindWaitError(err, rootFlags.WaitTimeout)
+	}
+
+	logger.Info("Kind cluster is ready.")
+
+	return conn, nil
+}
+
+// withWaitTimeout applies --wait-timeout to the context, if configured. The
+// timeout starts with the first wait and is shared by all following waits,
+// so that a command never waits longer than --wait-timeout in total.
+func withWaitTimeout(ctx context.Context, rootFlags *RootFlags) (context.Context, context.CancelFunc) {
+	if rootFlags.WaitTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	if rootFlags.waitDeadline.IsZero() {
+		rootFlags.waitDeadline = time.Now().Add(rootFlags.WaitTimeout)
+	}
+
+	return context.WithDeadline(ctx, rootFlags.waitDeadline)
+}
+
+// kindWaitError turns timeouts into more readable errors.
+func kindWaitError(err error, timeout time.Duration) error {
+	var notReady *kind.NotReadyError
+	if errors.As(err, &notReady) {
+		return waitError("kind cluster", err, notReady.LastErr, timeout)
+	}
+
+	return waitError("kind cluster", err, nil, timeout)
+}
+
+// kkpWaitError turns timeouts into more readable errors.
+func kkpWaitError(what string, err error, timeout time.Duration) error {
+	var notReady *kkp.NotReadyError
+	if errors.As(err, &notReady) {
+		return waitError(what, err, notReady.LastErr, timeout)
+	}
+
+	return waitError(what, err, nil, timeout)
+}
+
+func waitError(what string, err error, lastErr error, timeout time.Duration) error {
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	if lastErr != nil {
+		return fmt.Errorf("%s did not become ready within %v (last error: %w)", what, timeout, lastErr)
+	}
+
+	return fmt.Errorf("%s did not become ready within %v", what, timeout)
+}
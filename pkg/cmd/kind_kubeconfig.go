@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"go.xrstf.de/dj/pkg/prow"
+)
+
+type kindKubeconfigOptions struct {
+	WriteToFile bool
+	Forward     bool
+	Port        int
+	KindCluster string
+}
+
+func KindKubeconfigCommand(logger logrus.FieldLogger, rootFlags *RootFlags) *cobra.Command {
+	opt := kindKubeconfigOptions{}
+
+	cmd := &cobra.Command{
+		Use:          "kind-kubeconfig [ PROWJOB_ID | PROWJOB_POD_NAME ]",
+		Short:        "Retrieves the kubeconfig for accessing the kind cluster in an e2e job",
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return kindKubeconfigAction(c.Context(), logger.WithField("namespace", rootFlags.Namespace), rootFlags, &opt, args)
+		},
+	}
+
+	pFlags := cmd.PersistentFlags()
+	pFlags.BoolVarP(&opt.WriteToFile, "write", "w", opt.WriteToFile, "write the kubeconfig to a <buildid>-kind.kubeconfig file instead of outputting it on stdout")
+	pFlags.BoolVarP(&opt.Forward, "forward", "f", opt.Forward, "forward the kind API server to localhost and point the kubeconfig to it (blocks until Ctrl-C is pressed)")
+	pFlags.IntVarP(&opt.Port, "port", "p", opt.Port, "local port to forward the API server to (use 0 to pick a random free port)")
+	pFlags.StringVar(&opt.KindCluster, "kind-cluster", opt.KindCluster, "name of the kind cluster to use (required if the job runs multiple kind clusters)")
+
+	return cmd
+}
+
+func kindKubeconfigAction(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, opt *kindKubeconfigOptions, args []string) error {
+	if opt.Port < 0 || opt.Port > 65535 {
+		return fmt.Errorf("invalid local port %d", opt.Port)
+	}
+
+	container, err := rootFlags.Container()
+	if err != nil {
+		return err
+	}
+
+	ident, err := resolvePodIdentifier(ctx, logger, rootFlags, args)
+	if err != nil {
+		return err
+	}
+
+	// watch pods until we see the test container running
+	logger.WithFields(ident.Fields()).Info("Waiting for Pod to be running…")
+
+	pod, err := waitForPod(ctx, logger, rootFlags, ident, []string{container}, containerIsRunning(container), containerIsTerminated(container))
+	if err != nil {
+		return fmt.Errorf("failed to wait for Pod: %w", err)
+	}
+	if pod == nil {
+		return errors.New("Pod is terminated, cannot retrieve kubeconfig")
+	}
+
+	logger = logger.WithField("pod", pod.Name)
+
+	// the API server is always forwarded to check whether it is ready, but
+	// only --forward keeps the forwarding running
+	localPort := 0
+	if opt.Forward {
+		localPort = opt.Port
+	}
+
+	conn, err := connectKindCluster(ctx, logger, rootFlags, pod, container, opt.KindCluster, localPort)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	logger = logger.WithField("kindcluster", conn.Name)
+
+	kubeconfig := conn.Kubeconfig
+	if opt.Forward {
+		kubeconfig = conn.LocalKubeconfig
+
+		logger = logger.WithField("localport", conn.LocalPort)
+		logger.Infof("Port-forwarding is ready, kind API server is available at %s.", conn.RESTConfig.Host)
+	}
+
+	if opt.WriteToFile {
+		filename := fmt.Sprintf("%s-kind.kubeconfig", prow.PodName(pod))
+		logger.Infof("Writing kubeconfig to %s…", filename)
+
+		// use pretty strict permissions, because tools like Helm like to complain about it
+		if err := os.WriteFile(filename, kubeconfig, 0600); err != nil {
+			return fmt.Errorf("failed to write kubeconfig: %w", err)
+		}
+	} else {
+		fmt.Println(strings.TrimSpace(string(kubeconfig)))
+	}
+
+	if !opt.Forward {
+		return nil
+	}
+
+	logger.Info("Press Ctrl-C to stop port-forwarding.")
+
+	return conn.Wait()
+}
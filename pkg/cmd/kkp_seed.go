@@ -0,0 +1,205 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"go.xrstf.de/dj/pkg/kind"
+	"go.xrstf.de/dj/pkg/kkp"
+	"go.xrstf.de/dj/pkg/util"
+)
+
+type kkpSeedOptions struct {
+	WriteToFile  bool
+	Port         int
+	KindCluster  string
+	Seed         string
+	KKPNamespace string
+}
+
+func KKPSeedCommand(logger logrus.FieldLogger, rootFlags *RootFlags) *cobra.Command {
+	opt := kkpSeedOptions{
+		KKPNamespace: kkp.DefaultNamespace,
+	}
+
+	cmd := &cobra.Command{
+		Use:          "kkp-seed [ PROWJOB_ID | PROWJOB_POD_NAME ]",
+		Short:        "Waits for the KKP Seed in an e2e job and retrieves its kubeconfig",
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return kkpSeedAction(c.Context(), logger.WithField("namespace", rootFlags.Namespace), rootFlags, &opt, args)
+		},
+	}
+
+	pFlags := cmd.PersistentFlags()
+	pFlags.BoolVarP(&opt.WriteToFile, "write", "w", opt.WriteToFile, "write the kubeconfig to a <seed>-seed.kubeconfig file instead of outputting it on stdout")
+	pFlags.IntVarP(&opt.Port, "port", "p", opt.Port, "local port to forward the Seed's API server to (use 0 to pick a random free port)")
+	pFlags.StringVar(&opt.KindCluster, "kind-cluster", opt.KindCluster, "name of the kind cluster to use (required if the job runs multiple kind clusters)")
+	pFlags.StringVar(&opt.Seed, "seed", opt.Seed, "name of the Seed to use (required if the job creates multiple Seeds)")
+	pFlags.StringVar(&opt.KKPNamespace, "kkp-namespace", opt.KKPNamespace, "namespace KKP is installed into")
+
+	return cmd
+}
+
+func kkpSeedAction(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, opt *kkpSeedOptions, args []string) error {
+	if opt.Port < 0 || opt.Port > 65535 {
+		return fmt.Errorf("invalid local port %d", opt.Port)
+	}
+
+	container, err := rootFlags.Container()
+	if err != nil {
+		return err
+	}
+
+	ident, err := resolvePodIdentifier(ctx, logger, rootFlags, args)
+	if err != nil {
+		return err
+	}
+
+	// watch pods until we see the test container running
+	logger.WithFields(ident.Fields()).Info("Waiting for Pod to be running…")
+
+	pod, err := waitForPod(ctx, logger, rootFlags, ident, []string{container}, containerIsRunning(container), containerIsTerminated(container))
+	if err != nil {
+		return fmt.Errorf("failed to wait for Pod: %w", err)
+	}
+	if pod == nil {
+		return errors.New("Pod is terminated, cannot execute commands")
+	}
+
+	logger = logger.WithField("pod", pod.Name)
+
+	conn, err := connectKindCluster(ctx, logger, rootFlags, pod, container, opt.KindCluster, opt.Port)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	logger = logger.WithField("kindcluster", conn.Name)
+
+	waitCtx, cancel := withWaitTimeout(ctx, rootFlags)
+	defer cancel()
+
+	logger.Info("Waiting for KKP operator…")
+
+	if err := kkp.WaitForOperator(waitCtx, conn.ClientSet, opt.KKPNamespace); err != nil {
+		return kkpWaitError("KKP operator", err, rootFlags.WaitTimeout)
+	}
+
+	config, err := kkp.GetKubermaticConfiguration(waitCtx, conn.DynamicClient, opt.KKPNamespace)
+	if err != nil {
+		logger.Warnf("Failed to get KubermaticConfiguration: %v", err)
+	} else {
+		reportKubermaticConfiguration(logger, config)
+	}
+
+	logger.Info("Waiting for Seed…")
+
+	seed, err := kkp.WaitForSeed(waitCtx, conn.DynamicClient, opt.KKPNamespace, opt.Seed)
+	if err != nil {
+		return kkpWaitError("KKP Seed", err, rootFlags.WaitTimeout)
+	}
+
+	logger = logger.WithField("seed", seed.Name)
+	reportSeed(logger, seed)
+
+	logger.Info("Retrieving kubeconfig…")
+
+	kubeconfig, err := kkp.SeedKubeconfig(waitCtx, conn.ClientSet, seed)
+	if err != nil {
+		return err
+	}
+
+	// The Seed's own kubeconfig is meant for KKP and in e2e jobs points to an
+	// address only reachable from inside the kind cluster. If the Seed is the
+	// kind cluster itself, which is the usual setup, use the port-forwarding
+	// instead.
+	forward := seedIsKindCluster(kubeconfig, conn)
+	if forward {
+		kubeconfig = conn.LocalKubeconfig
+
+		logger = logger.WithField("localport", conn.LocalPort)
+		logger.Infof("Seed is the kind cluster, its API server is available at %s.", conn.RESTConfig.Host)
+	} else {
+		logger.Warn("Seed is not the kind cluster, its kubeconfig might not be usable outside of the Pod.")
+	}
+
+	if opt.WriteToFile {
+		filename := fmt.Sprintf("%s-seed.kubeconfig", seed.Name)
+		logger.Infof("Writing kubeconfig to %s…", filename)
+
+		// use pretty strict permissions, because tools like Helm like to complain about it
+		if err := os.WriteFile(filename, kubeconfig, 0600); err != nil {
+			return fmt.Errorf("failed to write kubeconfig: %w", err)
+		}
+	} else {
+		fmt.Println(strings.TrimSpace(string(kubeconfig)))
+	}
+
+	if !forward {
+		return nil
+	}
+
+	logger.Info("Press Ctrl-C to stop port-forwarding.")
+
+	return conn.Wait()
+}
+
+// seedIsKindCluster checks whether a Seed kubeconfig points to the kind
+// cluster, by comparing the cluster CAs.
+func seedIsKindCluster(seedKubeconfig []byte, conn *kind.Connection) bool {
+	seedCA, err := util.KubeconfigCA(seedKubeconfig)
+	if err != nil || len(seedCA) == 0 {
+		return false
+	}
+
+	kindCA, err := util.KubeconfigCA(conn.Kubeconfig)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(seedCA, kindCA)
+}
+
+func reportKubermaticConfiguration(logger logrus.FieldLogger, config *kkp.KubermaticConfiguration) {
+	logger = logger.WithField("config", config.Name)
+	if config.Version != "" {
+		logger = logger.WithField("version", config.Version)
+	}
+	if config.Edition != "" {
+		logger = logger.WithField("edition", config.Edition)
+	}
+
+	logger.Info("KubermaticConfiguration found.")
+	reportConditions(logger, config.Conditions)
+}
+
+func reportSeed(logger logrus.FieldLogger, seed *kkp.Seed) {
+	logger.WithField("phase", seed.Phase).Info("Seed is ready.")
+	reportConditions(logger, seed.Conditions)
+}
+
+func reportConditions(logger logrus.FieldLogger, conditions []kkp.Condition) {
+	for _, cond := range conditions {
+		condLogger := logger.WithField("status", cond.Status)
+		if cond.Reason != "" {
+			condLogger = condLogger.WithField("reason", cond.Reason)
+		}
+
+		if cond.Status == "True" {
+			condLogger.Infof("Condition %s", cond.Type)
+		} else {
+			condLogger.Warnf("Condition %s: %s", cond.Type, cond.Message)
+		}
+	}
+}
@@ -13,39 +13,40 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
-	"go.xrstf.de/dj/pkg/prow"
-	"go.xrstf.de/dj/pkg/util"
+	"go.xrstf.de/dj/pkg/kkp"
 )
 
+type kkpUserClusterOptions struct {
+	WriteToFile bool
+	KindCluster string
+}
+
 func KKPUserClusterCommand(logger logrus.FieldLogger, rootFlags *RootFlags) *cobra.Command {
-	var writeToFile = false
+	opt := kkpUserClusterOptions{}
 
 	cmd := &cobra.Command{
-		Use:          "kkp-usercluster ( PROWJOB_ID | PROWJOB_POD_NAME )",
+		Use:          "kkp-usercluster [ PROWJOB_ID | PROWJOB_POD_NAME ]",
 		Short:        "Retrieves the kubeconfig for accessing the KKP user cluster in an e2e job",
 		SilenceUsage: true,
 		RunE: func(c *cobra.Command, args []string) error {
-			return kkpUserClusterAction(c.Context(), logger.WithField("namespace", rootFlags.Namespace), rootFlags, writeToFile, args)
+			return kkpUserClusterAction(c.Context(), logger.WithField("namespace", rootFlags.Namespace), rootFlags, &opt, args)
 		},
 	}
 
 	pFlags := cmd.PersistentFlags()
-	pFlags.BoolVarP(&writeToFile, "write", "w", writeToFile, "write the kubeconfig to a <clusterid>.kubeconfig file instead of outputting it on stdout")
+	pFlags.BoolVarP(&opt.WriteToFile, "write", "w", opt.WriteToFile, "write the kubeconfig to a <clusterid>.kubeconfig file instead of outputting it on stdout")
+	pFlags.StringVar(&opt.KindCluster, "kind-cluster", opt.KindCluster, "name of the kind cluster to use (required if the job runs multiple kind clusters)")
 
 	return cmd
 }
 
-func kkpUserClusterAction(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, writeToFile bool, args []string) error {
-	if len(args) < 1 {
-		return errors.New("no job ID or Pod name given")
-	}
-
-	// default to running a shell
-	if len(args) < 2 {
-		args = append(args, "bash")
+func kkpUserClusterAction(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, opt *kkpUserClusterOptions, args []string) error {
+	container, err := rootFlags.Container()
+	if err != nil {
+		return err
 	}
 
-	ident, err := prow.ParsePodIdentifier(args[0])
+	ident, err := resolvePodIdentifier(ctx, logger, rootFlags, args)
 	if err != nil {
 		return err
 	}
@@ -53,9 +54,9 @@ func kkpUserClusterAction(ctx context.Context, logger logrus.FieldLogger, rootFl
 	// watch pods until we see the test container running
 	logger.WithFields(ident.Fields()).Info("Waiting for Pod to be running…")
 
-	pod, err := ident.WaitForPod(ctx, rootFlags.ClientSet, rootFlags.Namespace, podIsRunninng, podIsTerminated)
+	pod, err := waitForPod(ctx, logger, rootFlags, ident, []string{container}, containerIsRunning(container), containerIsTerminated(container))
 	if err != nil {
-		return fmt.Errorf("failed to watch Pods: %w", err)
+		return fmt.Errorf("failed to wait for Pod: %w", err)
 	}
 	if pod == nil {
 		return errors.New("Pod is terminated, cannot execute commands")
@@ -63,46 +64,41 @@ func kkpUserClusterAction(ctx context.Context, logger logrus.FieldLogger, rootFl
 
 	logger = logger.WithField("pod", pod.Name)
 
-	logger.Info("Waiting for Kind cluster to be available…")
-
-	script := strings.TrimSpace(util.KindClusterIsReadyScript)
-	if _, err := util.RunCommand(ctx, rootFlags.ClientSet, rootFlags.RESTConfig, pod, prow.TestContainerName, []string{"bash", "-c", script}, nil); err != nil {
+	conn, err := connectKindCluster(ctx, logger, rootFlags, pod, container, opt.KindCluster, 0)
+	if err != nil {
 		return err
 	}
+	defer conn.Close()
 
-	logger.Info("Kind cluster is ready.")
+	logger = logger.WithField("kindcluster", conn.Name)
+	logger.Info("Waiting for user cluster…")
 
-	clusterName := ""
-	if writeToFile {
-		logger.Info("Retrieving cluster name…")
+	waitCtx, cancel := withWaitTimeout(ctx, rootFlags)
+	defer cancel()
 
-		command := []string{"bash", "-c", util.OutputKKPUserClusterName}
-		clusterName, err = util.RunCommand(ctx, rootFlags.ClientSet, rootFlags.RESTConfig, pod, prow.TestContainerName, command, nil)
-		if err != nil {
-			return fmt.Errorf("failed to get cluster name: %w", err)
-		}
-
-		logger = logger.WithField("cluster", clusterName)
-		logger.Info("Cluster found.")
+	cluster, err := kkp.WaitForUserCluster(waitCtx, conn.DynamicClient)
+	if err != nil {
+		return kkpWaitError("KKP user cluster", err, rootFlags.WaitTimeout)
 	}
 
+	logger = logger.WithField("cluster", cluster.Name)
+	logger.Info("Cluster found.")
 	logger.Info("Retrieving kubeconfig…")
 
-	command := []string{"bash", "-c", util.OutputKKPUserClusterKubeconfig}
-	kubeconfig, err := util.RunCommand(ctx, rootFlags.ClientSet, rootFlags.RESTConfig, pod, prow.TestContainerName, command, nil)
+	kubeconfig, err := kkp.WaitForKubeconfig(waitCtx, conn.ClientSet, cluster)
 	if err != nil {
-		return fmt.Errorf("failed to get kubeconfig: %w", err)
+		return kkpWaitError("KKP user cluster kubeconfig", err, rootFlags.WaitTimeout)
 	}
 
-	if writeToFile {
-		filename := fmt.Sprintf("%s.kubeconfig", clusterName)
+	if opt.WriteToFile {
+		filename := fmt.Sprintf("%s.kubeconfig", cluster.Name)
 		logger.Infof("Writing kubeconfig to %s…", filename)
 
 		// use pretty strict permissions, because tools like Helm like to complain about it
-		return os.WriteFile(filename, []byte(kubeconfig), 0600)
+		return os.WriteFile(filename, kubeconfig, 0600)
 	}
 
-	fmt.Println(kubeconfig)
+	fmt.Println(strings.TrimSpace(string(kubeconfig)))
 
 	return nil
 }
@@ -0,0 +1,195 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"go.xrstf.de/dj/pkg/prow"
+
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/yaml"
+)
+
+type listOptions struct {
+	Output string
+	Job    string
+	Pull   string
+	Author string
+	State  string
+}
+
+func ListCommand(logger logrus.FieldLogger, rootFlags *RootFlags) *cobra.Command {
+	opt := listOptions{
+		Output: "table",
+	}
+
+	cmd := &cobra.Command{
+		Use:          "list",
+		Aliases:      []string{"ps"},
+		Short:        "List Prow job Pods",
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return listAction(c.Context(), logger.WithField("namespace", rootFlags.Namespace), rootFlags, &opt)
+		},
+	}
+
+	pFlags := cmd.PersistentFlags()
+	pFlags.StringVarP(&opt.Output, "output", "o", opt.Output, "output format (one of table, json, yaml)")
+	pFlags.StringVarP(&opt.Job, "job", "j", opt.Job, "only list jobs whose name matches this regular expression")
+	pFlags.StringVar(&opt.Pull, "pr", opt.Pull, "only list jobs for this PR number")
+	pFlags.StringVar(&opt.Author, "author", opt.Author, "only list jobs for PRs by this author")
+	pFlags.StringVar(&opt.State, "state", opt.State, "only list jobs in this state (one of pending, running, succeeded, failed, finished)")
+
+	return cmd
+}
+
+func listAction(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, opt *listOptions) error {
+	var jobRegex *regexp.Regexp
+	if opt.Job != "" {
+		var err error
+
+		jobRegex, err = regexp.Compile(opt.Job)
+		if err != nil {
+			return fmt.Errorf("invalid --job expression: %w", err)
+		}
+	}
+
+	switch opt.State {
+	case "", prow.JobStatePending, prow.JobStateRunning, prow.JobStateSucceeded, prow.JobStateFailed, "finished":
+	default:
+		return fmt.Errorf("invalid --state %q", opt.State)
+	}
+
+	selector := ""
+	if opt.Pull != "" {
+		selector = fmt.Sprintf("%s=%s", prow.PullLabel, opt.Pull)
+	}
+
+	logger.Debug("Listing Pods…")
+
+	jobs, err := prow.ListJobs(ctx, rootFlags.ClientSet, rootFlags.Namespace, selector)
+	if err != nil {
+		return err
+	}
+
+	filtered := []prow.Job{}
+	for _, job := range jobs {
+		if jobRegex != nil && !jobRegex.MatchString(job.Name) {
+			continue
+		}
+
+		if opt.Author != "" && !jobHasAuthor(job, opt.Author) {
+			continue
+		}
+
+		if !jobHasState(job, opt.State) {
+			continue
+		}
+
+		filtered = append(filtered, job)
+	}
+
+	switch opt.Output {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(filtered)
+
+	case "yaml":
+		encoded, err := yaml.Marshal(filtered)
+		if err != nil {
+			return fmt.Errorf("failed to encode jobs: %w", err)
+		}
+
+		_, err = os.Stdout.Write(encoded)
+		return err
+
+	case "table":
+		return printJobTable(os.Stdout, filtered)
+
+	default:
+		return fmt.Errorf("invalid --output %q", opt.Output)
+	}
+}
+
+// jobHasAuthor checks all authors, as batch jobs test multiple PRs.
+func jobHasAuthor(job prow.Job, author string) bool {
+	for _, a := range strings.Split(job.Author, ",") {
+		if strings.EqualFold(strings.TrimSpace(a), author) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func jobHasState(job prow.Job, state string) bool {
+	switch state {
+	case "":
+		return true
+	case "finished":
+		return job.State == prow.JobStateSucceeded || job.State == prow.JobStateFailed
+	default:
+		return job.State == state
+	}
+}
+
+func printJobTable(out io.Writer, jobs []prow.Job) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "JOB\tBUILD\tID\tPR\tAUTHOR\tSTATE\tAGE\tNODE")
+
+	for _, job := range jobs {
+		state := job.State
+		if job.StateDetail != "" {
+			state = fmt.Sprintf("%s (%s)", state, job.StateDetail)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			job.Name,
+			job.BuildID,
+			job.JobID,
+			orDash(jobPull(job)),
+			orDash(job.Author),
+			state,
+			duration.HumanDuration(time.Since(job.Created)),
+			orDash(job.Node),
+		)
+	}
+
+	return w.Flush()
+}
+
+func jobPull(job prow.Job) string {
+	if job.Pull == "" {
+		return ""
+	}
+
+	if job.Org == "" {
+		return fmt.Sprintf("#%s", job.Pull)
+	}
+
+	return fmt.Sprintf("%s/%s#%s", job.Org, job.Repo, job.Pull)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
@@ -4,87 +4,482 @@
 package cmd
 
 import (
+	"bufio"
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 
+	"go.xrstf.de/dj/pkg/logfilter"
 	"go.xrstf.de/dj/pkg/prow"
+	"go.xrstf.de/dj/pkg/util"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/ptr"
 )
 
+type logsOptions struct {
+	AllContainers bool
+	Tail          int64
+	Since         time.Duration
+	Timestamps    bool
+	Previous      bool
+	NoFollow      bool
+	Output        string
+	Gzip          bool
+	Grep          string
+	Exclude       string
+	Level         string
+	FailuresOnly  bool
+	NoColor       bool
+}
+
 func LogsCommand(logger logrus.FieldLogger, rootFlags *RootFlags) *cobra.Command {
+	opt := logsOptions{
+		Tail: -1,
+	}
+
 	cmd := &cobra.Command{
-		Use:          "logs ( PROWJOB_ID | PROWJOB_POD_NAME )",
+		Use:          "logs [ PROWJOB_ID | PROWJOB_POD_NAME ]",
 		Short:        "Stream the logs of the test container of a Prow job Pod",
 		SilenceUsage: true,
 		RunE: func(c *cobra.Command, args []string) error {
-			return logsAction(c.Context(), logger.WithField("namespace", rootFlags.Namespace), rootFlags, args)
+			return logsAction(c.Context(), logger.WithField("namespace", rootFlags.Namespace), rootFlags, &opt, args)
 		},
 	}
 
+	pFlags := cmd.PersistentFlags()
+	pFlags.BoolVarP(&opt.AllContainers, "all-containers", "a", opt.AllContainers, "stream the logs of all containers (including init containers) instead of just --container")
+	pFlags.Int64Var(&opt.Tail, "tail", opt.Tail, "number of recent log lines to show (-1 shows all lines)")
+	pFlags.DurationVar(&opt.Since, "since", opt.Since, "only show logs newer than this duration (e.g. 10m)")
+	pFlags.BoolVar(&opt.Timestamps, "timestamps", opt.Timestamps, "prefix every log line with its timestamp")
+	pFlags.BoolVarP(&opt.Previous, "previous", "p", opt.Previous, "show the logs of the previous instance of a restarted container")
+	pFlags.BoolVar(&opt.NoFollow, "no-follow", opt.NoFollow, "dump the existing logs and exit instead of following them")
+	pFlags.StringVarP(&opt.Output, "output", "o", opt.Output, "write logs to this file instead of stdout")
+	pFlags.BoolVarP(&opt.Gzip, "gzip", "z", opt.Gzip, "gzip-compress the --output file (implied if the filename ends with .gz)")
+	pFlags.StringVar(&opt.Grep, "grep", opt.Grep, "only show log lines matching this regular expression")
+	pFlags.StringVar(&opt.Exclude, "exclude", opt.Exclude, "hide log lines matching this regular expression")
+	pFlags.StringVar(&opt.Level, "level", opt.Level, "hide JSON log lines below this level (debug, info, warn, error, ...)")
+	pFlags.BoolVar(&opt.FailuresOnly, "failures-only", opt.FailuresOnly, "only show failed Ginkgo specs and Go tests (and the test summary)")
+	pFlags.BoolVar(&opt.NoColor, "no-color", opt.NoColor, "do not highlight FAIL/PASS/ERROR markers (disabled automatically when not writing to a terminal)")
+
 	return cmd
 }
 
-func logsAction(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, args []string) error {
-	if len(args) == 0 {
-		return errors.New("no job ID or Pod name given")
+func logsAction(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, opt *logsOptions, args []string) (err error) {
+	if opt.Gzip && opt.Output == "" {
+		return errors.New("--gzip requires --output")
+	}
+
+	filterOpts, err := opt.filterOptions()
+	if err != nil {
+		return err
 	}
 
-	ident, err := prow.ParsePodIdentifier(args[0])
+	ident, err := resolvePodIdentifier(ctx, logger, rootFlags, args)
 	if err != nil {
 		return err
 	}
 
+	// an empty list means "all containers"
+	containers := rootFlags.Containers
+	if opt.AllContainers {
+		containers = nil
+	}
+
 	// watch pods until we see the test container running
 	logger.WithFields(ident.Fields()).Info("Waiting for logs to be available…")
 
-	pod, err := ident.WaitForPod(ctx, rootFlags.ClientSet, rootFlags.Namespace, logsAvailable, nil)
+	pod, err := waitForPod(ctx, logger, rootFlags, ident, containers, logsAvailable(containers), nil)
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
 			return nil
 		}
 
-		return fmt.Errorf("failed to watch Pods: %w", err)
+		return fmt.Errorf("failed to wait for Pod: %w", err)
+	}
+
+	if len(containers) == 0 {
+		containers = podContainerNames(pod)
 	}
 
 	logger = logger.WithField("pod", pod.Name)
+
+	var output io.Writer = os.Stdout
+
+	if opt.Output != "" {
+		f, createErr := os.Create(opt.Output)
+		if createErr != nil {
+			return fmt.Errorf("failed to create output file: %w", createErr)
+		}
+		defer func() {
+			if closeErr := f.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}()
+
+		output = f
+
+		if opt.Gzip || strings.HasSuffix(opt.Output, ".gz") {
+			gz := gzip.NewWriter(f)
+
+			// this defer runs before closing the file
+			defer func() {
+				if closeErr := gz.Close(); closeErr != nil && err == nil {
+					err = closeErr
+				}
+			}()
+
+			output = gz
+		}
+
+		logger = logger.WithField("output", opt.Output)
+	}
+
 	logger.Info("Starting to stream logs")
 
-	request := rootFlags.ClientSet.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
-		Container: prow.TestContainerName,
-		Follow:    true,
-	})
+	options := opt.podLogOptions()
+
+	// a single container is streamed without prefixes
+	if len(containers) == 1 {
+		out := logfilter.NewWriter(output, filterOpts)
+
+		if err := streamLogs(ctx, logger, rootFlags, pod, containers[0], options, out); err != nil {
+			return err
+		}
+
+		return out.Flush()
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errs   = make([]error, len(containers))
+		prefix = maxLength(containers)
+	)
+
+	for idx, container := range containers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			prefixed := util.NewPrefixWriter(output, &mu, fmt.Sprintf("[%-*s] ", prefix, container))
+
+			// filter before prefixing, so that each container's lines are
+			// processed independently
+			out := logfilter.NewWriter(prefixed, filterOpts)
+
+			errs[idx] = streamLogs(ctx, logger, rootFlags, pod, container, options, out)
+			if err := errors.Join(out.Flush(), prefixed.Flush()); err != nil && errs[idx] == nil {
+				errs[idx] = err
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func (o *logsOptions) podLogOptions() corev1.PodLogOptions {
+	options := corev1.PodLogOptions{
+		Follow:     !o.NoFollow,
+		Timestamps: o.Timestamps,
+		Previous:   o.Previous,
+	}
+
+	if o.Tail >= 0 {
+		options.TailLines = ptr.To(o.Tail)
+	}
+
+	if o.Since > 0 {
+		// the API requires at least one second
+		options.SinceSeconds = ptr.To(int64(math.Ceil(o.Since.Seconds())))
+	}
+
+	return options
+}
+
+func (o *logsOptions) filterOptions() (logfilter.Options, error) {
+	options := logfilter.Options{
+		FailuresOnly: o.FailuresOnly,
+		Colorize:     !o.NoColor && o.Output == "" && term.IsTerminal(int(os.Stdout.Fd())),
+	}
+
+	if o.Grep != "" {
+		expr, err := regexp.Compile(o.Grep)
+		if err != nil {
+			return options, fmt.Errorf("invalid --grep expression: %w", err)
+		}
+
+		options.Grep = expr
+	}
+
+	if o.Exclude != "" {
+		expr, err := regexp.Compile(o.Exclude)
+		if err != nil {
+			return options, fmt.Errorf("invalid --exclude expression: %w", err)
+		}
+
+		options.Exclude = expr
+	}
+
+	level, err := logfilter.ParseLevel(o.Level)
+	if err != nil {
+		return options, fmt.Errorf("invalid --level: %w", err)
+	}
+
+	options.MinLevel = level
+
+	return options, nil
+}
+
+// logReconnectDelay is the time to wait before re-opening a log stream that
+// ended while its container was still running.
+const logReconnectDelay = 2 * time.Second
+
+// streamLogs copies the logs of a single container to out. When following the
+// logs, a stream that ends while the container is still running (e.g. because
+// the connection to the kubelet dropped) is transparently re-opened, starting
+// at the last received log line.
+func streamLogs(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, pod *corev1.Pod, container string, options corev1.PodLogOptions, out io.Writer) error {
+	logger = logger.WithField("container", container)
+	options.Container = container
+
+	follow := options.Follow && !options.Previous
+
+	// timestamps are required to resume streams
+	stripTimestamps := follow && !options.Timestamps
+	if follow {
+		options.Timestamps = true
+	}
+
+	lw := &logWriter{
+		out:             out,
+		stripTimestamps: stripTimestamps,
+	}
+
+	for {
+		err := lw.copy(ctx, rootFlags.ClientSet.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &options))
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if !follow {
+			return err
+		}
+
+		current, getErr := rootFlags.ClientSet.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if getErr != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("log stream ended and Pod could not be retrieved: %w", getErr)
+		}
 
+		terminated := containerTerminatedState(current, container)
+		if terminated == nil {
+			logger.WithError(err).Warn("Log stream ended while container is still running, reconnecting…")
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(logReconnectDelay):
+			}
+		}
+
+		// resume at the last line we have seen; the API only supports second
+		// precision, so logWriter takes care of skipping duplicate lines
+		if !lw.lastTimestamp.IsZero() {
+			options.SinceTime = &metav1.Time{Time: lw.lastTimestamp}
+			options.SinceSeconds = nil
+			options.TailLines = nil
+		}
+
+		if terminated != nil {
+			// the stream might have dropped before the container terminated,
+			// so fetch whatever was logged in the meantime
+			options.Follow = false
+
+			err := lw.copy(ctx, rootFlags.ClientSet.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &options))
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			logger.WithField("reason", terminated.Reason).Infof("Container has terminated with exit code %d.", terminated.ExitCode)
+
+			return err
+		}
+	}
+}
+
+// logWriter copies log lines (which are expected to start with their
+// timestamp) to an output and remembers which lines have already been
+// written, so that the same lines can be skipped when a stream is resumed.
+type logWriter struct {
+	out             io.Writer
+	stripTimestamps bool
+
+	lastTimestamp time.Time
+	// number of lines written with exactly lastTimestamp
+	lastCount int
+	// number of lines with exactly lastTimestamp seen in the current stream
+	seenCount int
+}
+
+func (w *logWriter) copy(ctx context.Context, request *rest.Request) error {
 	stream, err := request.Stream(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to stream logs: %w", err)
 	}
 	defer stream.Close()
 
-	if _, err := io.Copy(os.Stdout, stream); err != nil {
-		if errors.Is(err, context.Canceled) {
+	w.seenCount = 0
+
+	reader := bufio.NewReader(stream)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			if writeErr := w.writeLine(line); writeErr != nil {
+				return fmt.Errorf("failed to write logs: %w", writeErr)
+			}
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+func (w *logWriter) writeLine(line string) error {
+	timestamp, message, hasTimestamp := splitLogTimestamp(line)
+
+	if hasTimestamp {
+		switch {
+		case timestamp.Before(w.lastTimestamp):
 			return nil
+
+		case timestamp.Equal(w.lastTimestamp):
+			w.seenCount++
+			if w.seenCount <= w.lastCount {
+				return nil
+			}
+
+			w.lastCount++
+
+		default:
+			w.lastTimestamp = timestamp
+			w.lastCount = 1
+			w.seenCount = 1
 		}
 
-		return fmt.Errorf("failed to write to stdout: %w", err)
+		if w.stripTimestamps {
+			line = message
+		}
+	}
+
+	_, err := io.WriteString(w.out, line)
+
+	return err
+}
+
+func splitLogTimestamp(line string) (time.Time, string, bool) {
+	prefix, message, found := strings.Cut(line, " ")
+	if !found {
+		return time.Time{}, line, false
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, prefix)
+	if err != nil {
+		return time.Time{}, line, false
+	}
+
+	return timestamp, message, true
+}
+
+func containerTerminatedState(pod *corev1.Pod, container string) *corev1.ContainerStateTerminated {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			if status.Name == container {
+				return status.State.Terminated
+			}
+		}
 	}
 
 	return nil
 }
 
-func logsAvailable(pod *corev1.Pod) bool {
-	for _, status := range pod.Status.ContainerStatuses {
-		if status.Name == prow.TestContainerName {
-			return status.State.Running != nil || status.State.Terminated != nil
+// logsAvailable returns a checker that is satisfied once all given containers
+// have been started. If no containers are given, all containers in the Pod
+// are checked.
+func logsAvailable(containers []string) prow.PodCheckerFunc {
+	return func(pod *corev1.Pod) bool {
+		names := containers
+		if len(names) == 0 {
+			names = podContainerNames(pod)
+		}
+
+		for _, name := range names {
+			if !containerHasLogs(pod, name) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+func containerHasLogs(pod *corev1.Pod, container string) bool {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			if status.Name == container {
+				return status.State.Running != nil || status.State.Terminated != nil
+			}
 		}
 	}
 
 	// container has no status yet
 	return false
 }
+
+func podContainerNames(pod *corev1.Pod) []string {
+	var names []string
+
+	for _, container := range pod.Spec.InitContainers {
+		names = append(names, container.Name)
+	}
+
+	for _, container := range pod.Spec.Containers {
+		names = append(names, container.Name)
+	}
+
+	return names
+}
+
+func maxLength(values []string) int {
+	length := 0
+	for _, value := range values {
+		length = max(length, len(value))
+	}
+
+	return length
+}
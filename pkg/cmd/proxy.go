@@ -4,41 +4,81 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
 	"os"
-	"os/exec"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 
 	"go.xrstf.de/dj/pkg/prow"
+	"go.xrstf.de/dj/pkg/script"
 	"go.xrstf.de/dj/pkg/util"
+
+	corev1 "k8s.io/api/core/v1"
 )
 
+type proxyOptions struct {
+	Address         string
+	Port            int
+	RemotePort      int
+	WriteKubeconfig bool
+	PrintKubeconfig bool
+	KindCluster     string
+}
+
 func ProxyCommand(logger logrus.FieldLogger, rootFlags *RootFlags) *cobra.Command {
+	opt := proxyOptions{
+		Address: "localhost",
+		Port:    8080,
+	}
+
 	cmd := &cobra.Command{
 		Use:          "kind-proxy [ PROWJOB_ID | PROWJOB_POD_NAME ]",
-		Short:        "Tunnel through to a kind cluster running inside a Prow job pod, making it available on localhost:8080",
+		Short:        "Tunnel through to a kind cluster running inside a Prow job pod, making it available on localhost:8080 (by default)",
 		SilenceUsage: true,
 		RunE: func(c *cobra.Command, args []string) error {
-			return proxyAction(c.Context(), logger, rootFlags, args)
+			return proxyAction(c.Context(), logger, rootFlags, &opt, args)
 		},
 	}
 
+	pFlags := cmd.PersistentFlags()
+	pFlags.StringVar(&opt.Address, "address", opt.Address, "local address to listen on")
+	pFlags.IntVarP(&opt.Port, "port", "p", opt.Port, "local port to listen on (use 0 to pick a random free port)")
+	pFlags.IntVar(&opt.RemotePort, "remote-port", opt.RemotePort, "port for kubectl-proxy inside the test container (0 picks a random free port)")
+	pFlags.BoolVarP(&opt.WriteKubeconfig, "write", "w", opt.WriteKubeconfig, "write a kubeconfig for the proxied cluster to a <buildid>.kubeconfig file")
+	pFlags.BoolVar(&opt.PrintKubeconfig, "print-kubeconfig", opt.PrintKubeconfig, "output a kubeconfig for the proxied cluster on stdout")
+	pFlags.StringVar(&opt.KindCluster, "kind-cluster", opt.KindCluster, "name of the kind cluster to use (required if the job runs multiple kind clusters)")
+
 	return cmd
 }
 
-func proxyAction(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, args []string) error {
-	if len(args) == 0 {
-		return errors.New("no job ID or Pod name given")
+func proxyAction(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, opt *proxyOptions, args []string) error {
+	if opt.Port < 0 || opt.Port > 65535 {
+		return fmt.Errorf("invalid local port %d", opt.Port)
+	}
+
+	if opt.RemotePort < 0 || opt.RemotePort > 65535 {
+		return fmt.Errorf("invalid remote port %d", opt.RemotePort)
+	}
+
+	container, err := rootFlags.Container()
+	if err != nil {
+		return err
 	}
 
-	ident, err := prow.ParsePodIdentifier(args[0])
+	ident, err := resolvePodIdentifier(ctx, logger, rootFlags, args)
 	if err != nil {
 		return err
 	}
@@ -47,120 +87,314 @@ func proxyAction(ctx context.Context, logger logrus.FieldLogger, rootFlags *Root
 	logger = logger.WithFields(ident.Fields())
 	logger.Info("Waiting for Pod to be running…")
 
-	pod, err := ident.WaitForPod(ctx, rootFlags.ClientSet, rootFlags.Namespace, podIsRunninng, podIsTerminated)
+	pod, err := waitForPod(ctx, logger, rootFlags, ident, []string{container}, containerIsRunning(container), containerIsTerminated(container))
 	if err != nil {
-		return fmt.Errorf("failed to watch Pods: %w", err)
+		return fmt.Errorf("failed to wait for Pod: %w", err)
 	}
 	if pod == nil {
 		return errors.New("Pod is terminated, cannot create proxy")
 	}
 
 	logger = logger.WithField("pod", pod.Name)
-	logger.Info("Retrieving cluster name…")
 
-	logger.Info("Waiting for Kind cluster to be available…")
+	// the connection is only used to check the cluster, the actual proxy
+	// runs inside the test container
+	conn, err := connectKindCluster(ctx, logger, rootFlags, pod, container, opt.KindCluster, 0)
+	if err != nil {
+		return err
+	}
 
-	script := strings.TrimSpace(util.KindClusterIsReadyScript)
-	if _, err := util.RunCommand(ctx, rootFlags.ClientSet, rootFlags.RESTConfig, pod, prow.TestContainerName, []string{"bash", "-c", script}, nil); err != nil {
+	conn.Close()
+
+	kindCluster := conn.Name
+	logger = logger.WithField("kindcluster", kindCluster)
+
+	// concurrent proxies into the same Pod must not share a port
+	remotePort, err := freeRemotePort(ctx, rootFlags, pod, container, opt.RemotePort)
+	if err != nil {
 		return err
 	}
 
-	logger.Info("Kind cluster is ready.")
+	opt.RemotePort = remotePort
 
-	kubectlCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	// the port-forwarding and the kubectl-proxy session run together, if
+	// either of them ends, the other one is stopped as well
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+
+	logger = logger.WithField("remoteport", opt.RemotePort)
+	logger.Info("Forwarding kubectl-proxy to localhost…")
+
+	// the terminal is switched into raw mode while kubectl-proxy runs
+	sessionLogger := rawTerminalLogger(logger)
+
+	readyChan := make(chan int, 1)
+	fwEnded := make(chan error, 1)
+
+	// set once kubectl-proxy has been started
+	var proxying atomic.Bool
 
-	cmd := exec.CommandContext(kubectlCtx, "kubectl", "--kubeconfig", rootFlags.Kubeconfig, "--namespace", pod.Namespace, "port-forward", pod.Name, "8080:27251")
-	if err := cmd.Start(); err != nil {
-		logger.WithError(err).Error("Failed to start kubectl.")
-	}
-	time.Sleep(2 * time.Second)
-
-	// The following is the sane solution, but it has the weird drawback that if someone tries to use
-	// the port-forwarding before the kubectl-proxy command is started, the forwarding simply ends with
-	// no error being returned. The errors printed on stderr are identical to the errors that kubectl
-	// would produce, but for some reason kubectl's port-forward doesn't end, it just retries. I checked
-	// the source code but cannot find where it does that.
-	// So ...... with a heavy heart, the above code snippet is the bruteforce stupid solution. Please
-	// submit a patch to make this work. Please, someone...
-
-	// request := rootFlags.ClientSet.CoreV1().RESTClient().
-	// 	Post().
-	// 	Resource("pods").
-	// 	Name(pod.Name).
-	// 	Namespace(rootFlags.Namespace).
-	// 	SubResource("portforward")
-
-	// transport, upgrader, err := spdy.RoundTripperFor(rootFlags.RESTConfig)
-	// if err != nil {
-	// 	return err
-	// }
-
-	// dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", request.URL())
-	// logger = logger.WithField("localport", 8080).WithField("remoteport", 27251)
-
-	// readyChan := make(chan struct{})
-	// fwCtx, cancel := context.WithCancel(ctx)
-	// defer cancel()
-
-	// fw, err := portforward.New(dialer, []string{"8080:27251"}, fwCtx.Done(), readyChan, io.Discard, io.Discard)
-	// if err != nil {
-	// 	return err
-	// }
-
-	// fwEnded := make(chan struct{})
-
-	// go func() {
-	// 	logger.Info("Forwarding kubectl-proxy to localhost…")
-
-	// 	// for {
-	// 	// 	select {
-	// 	// 	case <-fwCtx.Done():
-	// 	// 		logger.Info("Forwarding ended.")
-	// 	// 		close(fwEnded)
-	// 	// 		return
-
-	// 	// 	default:
-	// 	// 		if err := fw.ForwardPorts(); err != nil {
-	// 	// 			logger.WithError(err).Error("Port-forwarding failed.")
-	// 	// 		}
-	// 	// 	}
-	// 	// }
-
-	// 	if err := fw.ForwardPorts(); err != nil {
-	// 		logger.WithError(err).Error("Port-forwarding failed.")
-	// 	}
-	// 	logger.Info("Forwarding ended.")
-	// 	close(fwEnded)
-	// }()
-
-	// <-readyChan
-	logger.Info("Port-forwarding is ready.")
+	go func() {
+		err := util.PortForward(runCtx, sessionLogger, rootFlags.ClientSet, rootFlags.RESTConfig, pod, opt.Address, opt.Port, opt.RemotePort, readyChan)
+		if err != nil && runCtx.Err() == nil && proxying.Load() {
+			sessionLogger.WithError(err).Error("Port-forwarding has failed, stopping kubectl-proxy…")
+		}
+
+		cancelRun()
+		fwEnded <- err
+	}()
+
+	var localPort int
+
+	select {
+	case localPort = <-readyChan:
+	case err := <-fwEnded:
+		return err
+	}
+
+	server := fmt.Sprintf("http://%s", net.JoinHostPort(opt.Address, strconv.Itoa(localPort)))
+
+	logger = logger.WithField("localport", localPort)
+	sessionLogger = sessionLogger.WithField("localport", localPort)
+
+	if opt.WriteKubeconfig || opt.PrintKubeconfig {
+		name := prow.PodName(pod)
+
+		kubeconfig, err := util.ProxyKubeconfig(name, server)
+		if err != nil {
+			return fmt.Errorf("failed to create kubeconfig: %w", err)
+		}
+
+		if opt.WriteKubeconfig {
+			filename := fmt.Sprintf("%s.kubeconfig", name)
+			logger.Infof("Writing kubeconfig to %s…", filename)
+
+			// use pretty strict permissions, because tools like Helm like to complain about it
+			if err := os.WriteFile(filename, kubeconfig, 0600); err != nil {
+				return fmt.Errorf("failed to write kubeconfig: %w", err)
+			}
+		}
+
+		if opt.PrintKubeconfig {
+			fmt.Print(string(kubeconfig))
+		}
+	}
 
 	// establish a kubectl proxy inside the test container, which makes the kube API
 	// available without authentication on a local port inside the test container
 	// (the one we just created a port-forwarding to); this command will block until
 	// the user Ctrl-C's out.
-	// Note that it's not possible to interrupt a SPDY connection, so it is effectively
-	// impossible to cancel a running command. For this reason we cannot have this
-	// command run in the background (without a TTY attached), because then we can never
-	// kill it and even when dj ends, the bash will continue to run, and this will
-	// block the temporary port and could lead to trouble when a second proxy is attempted.
-	// Due to this, we instead make the kubectl-proxy command interactive and attach the TTY,
-	// so that any Ctrl-C will be caught by the bash in the container and the kubectl-proxy
-	// can be stopped as intended.
-	logger.Info("Proxying Kind cluster to localhost…")
-
-	script = strings.TrimSpace(util.CreateKindClusterProxyScript)
-	err = util.RunCommandWithTTY(ctx, rootFlags.ClientSet, rootFlags.RESTConfig, pod, prow.TestContainerName, []string{"bash", "-c", script}, os.Stdin, io.Discard, io.Discard)
+	// The kubectl-proxy command is interactive and has the TTY attached, so that
+	// any Ctrl-C will be caught by the bash in the container and the kubectl-proxy
+	// can be stopped as intended. In case dj is stopped in any other way (which
+	// merely closes the connection), the proxy is additionally stopped using its
+	// PID file afterwards.
+	logger.Info("Starting kubectl-proxy…")
+
+	// the PID file is unique per invocation, so that concurrent proxies never
+	// stop each other's kubectl-proxy, even if they happen to use the same port
+	pidFile := script.PIDFile(fmt.Sprintf("kubectl-proxy-%d-%08x", opt.RemotePort, rand.Uint32()))
+
+	command, err := script.KindClusterProxy.Command(ctx, script.Params{
+		"ClusterName": kindCluster,
+		"Port":        opt.RemotePort,
+		"PIDFile":     pidFile,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to run proxy: %w", err)
+		return err
+	}
+
+	sessionEnded := make(chan error, 1)
+	sessionInterrupted := false
+	proxying.Store(true)
+
+	go func() {
+		err := util.RunCommandWithTTY(runCtx, rootFlags.ClientSet, rootFlags.RESTConfig, pod, container, command, os.Stdin, io.Discard, io.Discard)
+		sessionInterrupted = runCtx.Err() != nil
+		cancelRun()
+		sessionEnded <- err
+	}()
+
+	// the port-forwarding is only usable once kubectl-proxy is listening
+	waitCtx, cancelWait := withWaitTimeout(runCtx, rootFlags)
+	proxyErr := waitForProxy(waitCtx, server)
+	cancelWait()
+
+	if proxyErr == nil {
+		sessionLogger.Infof("kubectl-proxy is ready, kind cluster is available at %s.", server)
+	} else if runCtx.Err() == nil {
+		proxyErr = fmt.Errorf("kubectl-proxy did not become ready: %w", proxyErr)
+		sessionLogger.WithError(proxyErr).Error("Stopping kubectl-proxy…")
+		cancelRun()
+	} else {
+		proxyErr = nil
+	}
+
+	<-runCtx.Done()
+
+	sessionErr := <-sessionEnded
+
+	// if the session ended by itself, kubectl-proxy has already stopped and
+	// the script removed its PID file; only an interrupted session can leave
+	// kubectl-proxy running in the container
+	if sessionInterrupted {
+		stopRemoteProcess(ctx, logger, rootFlags, pod, container, pidFile)
 	}
 
-	// Now the kubectl-proxy has ended, we can stop the port forwarding
 	logger.Info("Stopping port-forwarding…")
-	cancel()
-	// <-fwEnded
 
-	return cmd.Wait()
+	fwErr := <-fwEnded
+
+	switch {
+	case ctx.Err() != nil:
+		return nil
+	case fwErr != nil:
+		return fmt.Errorf("port-forwarding failed: %w", fwErr)
+	case proxyErr != nil:
+		return proxyErr
+	case sessionErr != nil:
+		return fmt.Errorf("failed to run proxy: %w", sessionErr)
+	}
+
+	return nil
+}
+
+// waitForProxy waits until the kubectl-proxy behind the given URL responds.
+func waitForProxy(ctx context.Context, server string) error {
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	var lastErr error
+
+	err := util.Poll(ctx, util.DefaultBackoff, func(ctx context.Context) (bool, error) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, server+"/version", nil)
+		if err != nil {
+			return false, err
+		}
+
+		response, err := client.Do(request)
+		if err != nil {
+			lastErr = err
+			return false, nil
+		}
+		response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			lastErr = fmt.Errorf("unexpected status %s", response.Status)
+			return false, nil
+		}
+
+		return true, nil
+	})
+	if err != nil && lastErr != nil {
+		return fmt.Errorf("%w (last error: %w)", err, lastErr)
+	}
+
+	return err
+}
+
+// rawTerminalLogger returns a logger that can be used while the terminal is in
+// raw mode, in which line feeds do not return the cursor to the start of the
+// line anymore.
+func rawTerminalLogger(logger logrus.FieldLogger) logrus.FieldLogger {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return logger
+	}
+
+	var (
+		base   *logrus.Logger
+		fields logrus.Fields
+	)
+
+	switch l := logger.(type) {
+	case *logrus.Logger:
+		base = l
+	case *logrus.Entry:
+		base = l.Logger
+		fields = l.Data
+	default:
+		return logger
+	}
+
+	raw := logrus.New()
+	raw.SetFormatter(base.Formatter)
+	raw.SetLevel(base.GetLevel())
+	raw.SetOutput(&crlfWriter{out: base.Out})
+
+	return raw.WithFields(fields)
+}
+
+// crlfWriter turns line feeds into CRLF.
+type crlfWriter struct {
+	out io.Writer
+}
+
+func (w *crlfWriter) Write(p []byte) (int, error) {
+	if _, err := w.out.Write(bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// The range for randomly chosen kubectl-proxy ports inside the test container,
+// chosen to stay clear of the ephemeral port range.
+const (
+	minRandomRemotePort = 20000
+	maxRandomRemotePort = 30000
+)
+
+// freeRemotePort checks that nothing in the container listens on the given
+// port; if the port is 0, a random free port is chosen.
+func freeRemotePort(ctx context.Context, rootFlags *RootFlags, pod *corev1.Pod, container string, port int) (int, error) {
+	params := script.Params{
+		"Min": minRandomRemotePort,
+		"Max": maxRandomRemotePort,
+	}
+
+	if port != 0 {
+		params = script.Params{
+			"Min": port,
+			"Max": port + 1,
+		}
+	}
+
+	command, err := script.FreePort.Command(ctx, params)
+	if err != nil {
+		return 0, err
+	}
+
+	output, err := util.RunCommand(ctx, rootFlags.ClientSet, rootFlags.RESTConfig, pod, container, command, nil)
+	if err != nil {
+		if port != 0 {
+			return 0, fmt.Errorf("remote port %d is already in use", port)
+		}
+
+		return 0, fmt.Errorf("failed to find a free remote port: %w", err)
+	}
+
+	free, err := strconv.Atoi(strings.TrimSpace(output))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find a free remote port: unexpected output %q", output)
+	}
+
+	return free, nil
+}
+
+// remoteCleanupTimeout is the maximum time for stopping remote processes.
+const remoteCleanupTimeout = 10 * time.Second
+
+// stopRemoteProcess kills the process in the container whose PID is stored
+// in the given PID file. This also works when ctx has already been cancelled.
+func stopRemoteProcess(ctx context.Context, logger logrus.FieldLogger, rootFlags *RootFlags, pod *corev1.Pod, container string, pidFile string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), remoteCleanupTimeout)
+	defer cancel()
+
+	command, err := script.StopPIDFile.Command(ctx, script.Params{"PIDFile": pidFile})
+	if err == nil {
+		_, err = util.RunCommand(ctx, rootFlags.ClientSet, rootFlags.RESTConfig, pod, container, command, nil)
+	}
+
+	if err != nil {
+		logger.WithError(err).WithField("pidfile", pidFile).Warn("Failed to stop remote process.")
+	}
 }
@@ -4,28 +4,54 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
+	"go.xrstf.de/dj/pkg/prow"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 type RootFlags struct {
-	Kubeconfig string
-	Namespace  string
-	RESTConfig *rest.Config
-	ClientSet  *kubernetes.Clientset
-	Verbose    bool
+	Kubeconfig       string
+	Namespace        string
+	ProwJobNamespace string
+	RESTConfig       *rest.Config
+	ClientSet        *kubernetes.Clientset
+	DynamicClient    dynamic.Interface
+	WaitTimeout      time.Duration
+	PodName          string
+	PodIndex         int
+	SelectPod        bool
+	Containers       []string
+	Verbose          bool
+
+	// waitDeadline is set by the first wait and then applies to all waits.
+	waitDeadline time.Time
+}
+
+// Container returns the single container that a command should operate on.
+func (f *RootFlags) Container() (string, error) {
+	if len(f.Containers) != 1 {
+		return "", errors.New("exactly one --container must be given for this command")
+	}
+
+	return f.Containers[0], nil
 }
 
 func RootCommand(logger *logrus.Logger, version string) (*cobra.Command, *RootFlags) {
 	opt := RootFlags{
-		Namespace: metav1.NamespaceDefault,
+		Namespace:  metav1.NamespaceDefault,
+		Containers: []string{prow.TestContainerName},
 	}
 
 	cmd := &cobra.Command{
@@ -52,6 +78,15 @@ func RootCommand(logger *logrus.Logger, version string) (*cobra.Command, *RootFl
 				logger.Fatalf("Failed to create Kubernetes clientset: %v", err)
 			}
 
+			opt.DynamicClient, err = dynamic.NewForConfig(opt.RESTConfig)
+			if err != nil {
+				logger.Fatalf("Failed to create dynamic Kubernetes client: %v", err)
+			}
+
+			if opt.ProwJobNamespace == "" {
+				opt.ProwJobNamespace = opt.Namespace
+			}
+
 			return nil
 		},
 	}
@@ -59,7 +94,23 @@ func RootCommand(logger *logrus.Logger, version string) (*cobra.Command, *RootFl
 	pFlags := cmd.PersistentFlags()
 	pFlags.StringVar(&opt.Kubeconfig, "kubeconfig", opt.Kubeconfig, "kubeconfig file to use (uses $KUBECONFIG by default)")
 	pFlags.StringVarP(&opt.Namespace, "namespace", "n", opt.Namespace, "Kubernetes namespace where Prow jobs are running in")
+	pFlags.StringVar(&opt.ProwJobNamespace, "prowjob-namespace", opt.ProwJobNamespace, "Kubernetes namespace where ProwJob objects are stored in (defaults to --namespace)")
+	pFlags.DurationVar(&opt.WaitTimeout, "wait-timeout", opt.WaitTimeout, "maximum total time to wait for a Pod and the kind/KKP clusters inside it to become ready (0 disables the timeout)")
+	pFlags.StringVar(&opt.PodName, "pod", opt.PodName, "name of the Pod to use if multiple Pods match the job (e.g. retries)")
+	pFlags.IntVar(&opt.PodIndex, "pod-index", opt.PodIndex, "index of the Pod to use if multiple Pods match the job, sorted newest first")
+	pFlags.BoolVar(&opt.SelectPod, "select-pod", opt.SelectPod, "interactively select the Pod to use if multiple Pods match the job")
+	pFlags.StringSliceVarP(&opt.Containers, "container", "c", opt.Containers, "container(s) in the Prow job Pod to use (only logs supports multiple containers)")
 	pFlags.BoolVarP(&opt.Verbose, "verbose", "v", opt.Verbose, "Enable more verbose output")
 
 	return cmd, &opt
 }
+
+// ExitCodeError is returned by commands that want dj to exit with a specific
+// exit code (like the code of a remote command) without reporting a failure.
+type ExitCodeError struct {
+	Code int
+}
+
+func (e *ExitCodeError) Error() string {
+	return fmt.Sprintf("exit code %d", e.Code)
+}
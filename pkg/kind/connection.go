@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package kind
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"go.xrstf.de/dj/pkg/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Connection is a port-forwarding to the API server of a kind cluster.
+type Connection struct {
+	Name string
+	// Kubeconfig is the kubeconfig as reported by kind, only usable inside
+	// the Pod.
+	Kubeconfig []byte
+	// LocalKubeconfig points to the forwarded local port.
+	LocalKubeconfig []byte
+	LocalPort       int
+
+	RESTConfig    *rest.Config
+	ClientSet     *kubernetes.Clientset
+	DynamicClient dynamic.Interface
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Connect forwards the API server of the given cluster to the given local
+// port (0 picks a random free port). The kubeconfig must have been retrieved
+// using Kubeconfig. The forwarding lasts until the context is cancelled or
+// Close is called.
+func (p *Provider) Connect(ctx context.Context, name string, kubeconfig []byte, localPort int) (*Connection, error) {
+	server, err := util.KubeconfigServer(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+
+	// kind publishes the API server on a random port on the loopback
+	// interface, i.e. inside the network namespace of the Pod
+	remotePort, err := strconv.Atoi(server.Port())
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine API server port from %q: %w", server, err)
+	}
+
+	fwCtx, cancel := context.WithCancel(ctx)
+
+	conn := &Connection{
+		Name:       name,
+		Kubeconfig: kubeconfig,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+
+	logger := p.logger.WithField("remoteport", remotePort)
+	readyChan := make(chan int, 1)
+
+	go func() {
+		conn.err = util.PortForward(fwCtx, logger, p.clientset, p.restConfig, p.pod, "localhost", localPort, remotePort, readyChan)
+		close(conn.done)
+	}()
+
+	select {
+	case conn.LocalPort = <-readyChan:
+	case <-conn.done:
+		cancel()
+
+		if conn.err == nil {
+			conn.err = ctx.Err()
+		}
+
+		return nil, conn.err
+	}
+
+	// kind's serving certificate is valid for 127.0.0.1, so stick to it
+	server.Host = net.JoinHostPort("127.0.0.1", strconv.Itoa(conn.LocalPort))
+
+	if err := conn.setupClients(server.String()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (c *Connection) setupClients(server string) error {
+	var err error
+
+	c.LocalKubeconfig, err = util.RewriteKubeconfigServer(c.Kubeconfig, server)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite kubeconfig: %w", err)
+	}
+
+	c.RESTConfig, err = clientcmd.RESTConfigFromKubeConfig(c.LocalKubeconfig)
+	if err != nil {
+		return fmt.Errorf("failed to create REST config: %w", err)
+	}
+
+	c.ClientSet, err = kubernetes.NewForConfig(c.RESTConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create Kubernetes clientset: %w", err)
+	}
+
+	c.DynamicClient, err = dynamic.NewForConfig(c.RESTConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic Kubernetes client: %w", err)
+	}
+
+	return nil
+}
+
+// WaitForReady waits until the API server responds to requests.
+func (c *Connection) WaitForReady(ctx context.Context) error {
+	var lastErr error
+
+	err := util.Poll(ctx, util.DefaultBackoff, func(ctx context.Context) (bool, error) {
+		_, lastErr = c.ClientSet.CoreV1().Namespaces().List(ctx, metav1.ListOptions{Limit: 1})
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		return &NotReadyError{Cluster: c.Name, Err: err, LastErr: lastErr}
+	}
+
+	return nil
+}
+
+// Wait blocks until the port-forwarding has ended.
+func (c *Connection) Wait() error {
+	<-c.done
+	return c.err
+}
+
+// Close stops the port-forwarding.
+func (c *Connection) Close() error {
+	c.cancel()
+	return c.Wait()
+}
@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package kind
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoClusters is returned when no kind cluster exists in the Pod.
+var ErrNoClusters = errors.New("no kind cluster found")
+
+// AmbiguousClusterError is returned when multiple kind clusters exist, but
+// none of them was chosen explicitly.
+type AmbiguousClusterError struct {
+	Clusters []string
+}
+
+func (e *AmbiguousClusterError) Error() string {
+	return fmt.Sprintf("found multiple kind clusters (%s), use --kind-cluster to choose one", strings.Join(e.Clusters, ", "))
+}
+
+// NotReadyError is returned when waiting for a kind cluster was aborted,
+// usually because the context was cancelled or timed out.
+type NotReadyError struct {
+	// Cluster is empty when waiting for any cluster to be created.
+	Cluster string
+	// Err is the reason why waiting was aborted.
+	Err error
+	// LastErr is the last error encountered while checking the cluster.
+	LastErr error
+}
+
+func (e *NotReadyError) Error() string {
+	msg := fmt.Sprintf("no kind cluster became ready: %v", e.Err)
+	if e.Cluster != "" {
+		msg = fmt.Sprintf("kind cluster %q did not become ready: %v", e.Cluster, e.Err)
+	}
+
+	if e.LastErr != nil {
+		msg = fmt.Sprintf("%s (last error: %v)", msg, e.LastErr)
+	}
+
+	return msg
+}
+
+func (e *NotReadyError) Unwrap() error {
+	return e.Err
+}
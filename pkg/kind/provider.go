@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+// Package kind provides access to kind clusters running inside a Prow job
+// Pod. Only the kind binary is used inside the Pod, to retrieve the kubeconfig
+// once, everything else is done by talking to the kind API server directly,
+// using a port-forwarding into the Pod.
+package kind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"go.xrstf.de/dj/pkg/util"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Provider gives access to the kind clusters running inside a container of
+// a Prow job Pod.
+type Provider struct {
+	logger     logrus.FieldLogger
+	clientset  *kubernetes.Clientset
+	restConfig *rest.Config
+	pod        *corev1.Pod
+	container  string
+}
+
+func NewProvider(logger logrus.FieldLogger, clientset *kubernetes.Clientset, restConfig *rest.Config, pod *corev1.Pod, container string) *Provider {
+	return &Provider{
+		logger:     logger,
+		clientset:  clientset,
+		restConfig: restConfig,
+		pod:        pod,
+		container:  container,
+	}
+}
+
+// Clusters returns the names of all kind clusters.
+func (p *Provider) Clusters(ctx context.Context) ([]string, error) {
+	output, err := p.run(ctx, "get", "clusters")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list kind clusters: %w", err)
+	}
+
+	// kind prints "No kind clusters found." to stderr, so stdout is empty
+	return strings.Fields(output), nil
+}
+
+// stableClusterPolls is the number of consecutive polls that must return the
+// same clusters before WaitForClusters considers the list to be complete.
+const stableClusterPolls = 3
+
+// WaitForClusters waits until at least one kind cluster exists and returns
+// the names of all clusters. As jobs can create multiple clusters one after
+// another, the list must not change for a few polls before it is returned.
+func (p *Provider) WaitForClusters(ctx context.Context) ([]string, error) {
+	var (
+		clusters []string
+		lastErr  error
+		previous []string
+		stable   int
+	)
+
+	err := util.Poll(ctx, util.DefaultBackoff, func(ctx context.Context) (bool, error) {
+		clusters, lastErr = p.Clusters(ctx)
+		if lastErr == nil && len(clusters) == 0 {
+			lastErr = ErrNoClusters
+		}
+
+		if lastErr != nil {
+			previous, stable = nil, 0
+			return false, nil
+		}
+
+		if slices.Equal(clusters, previous) {
+			stable++
+		} else {
+			p.logger.WithField("clusters", clusters).Debug("Found kind clusters, waiting for more to appear…")
+			previous, stable = clusters, 1
+		}
+
+		return stable >= stableClusterPolls, nil
+	})
+	if err != nil {
+		return nil, &NotReadyError{Err: err, LastErr: lastErr}
+	}
+
+	return clusters, nil
+}
+
+// WaitForCluster waits until the given kind cluster exists.
+func (p *Provider) WaitForCluster(ctx context.Context, name string) error {
+	var lastErr error
+
+	err := util.Poll(ctx, util.DefaultBackoff, func(ctx context.Context) (bool, error) {
+		var clusters []string
+
+		clusters, lastErr = p.Clusters(ctx)
+		if lastErr == nil && !slices.Contains(clusters, name) {
+			lastErr = fmt.Errorf("cluster does not exist (yet), found %v", clusters)
+		}
+
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		return &NotReadyError{Cluster: name, Err: err, LastErr: lastErr}
+	}
+
+	return nil
+}
+
+// Kubeconfig waits for the kubeconfig of the given cluster to be available
+// and returns it. The kubeconfig points to the loopback interface inside the
+// Pod, see Connect to access the cluster from outside of the Pod.
+func (p *Provider) Kubeconfig(ctx context.Context, name string) ([]byte, error) {
+	var (
+		kubeconfig string
+		lastErr    error
+	)
+
+	err := util.Poll(ctx, util.DefaultBackoff, func(ctx context.Context) (bool, error) {
+		kubeconfig, lastErr = p.run(ctx, "get", "kubeconfig", "--name", name)
+		if lastErr == nil && strings.TrimSpace(kubeconfig) == "" {
+			lastErr = errors.New("kubeconfig is empty")
+		}
+
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		return nil, &NotReadyError{Cluster: name, Err: err, LastErr: lastErr}
+	}
+
+	return []byte(kubeconfig), nil
+}
+
+// ChooseCluster returns the only cluster in the list, or an error if there
+// is not exactly one cluster.
+func ChooseCluster(clusters []string) (string, error) {
+	switch len(clusters) {
+	case 0:
+		return "", ErrNoClusters
+	case 1:
+		return clusters[0], nil
+	default:
+		return "", &AmbiguousClusterError{Clusters: clusters}
+	}
+}
+
+func (p *Provider) run(ctx context.Context, args ...string) (string, error) {
+	command := append([]string{"kind"}, args...)
+
+	return util.RunCommand(ctx, p.clientset, p.restConfig, p.pod, p.container, command, nil)
+}
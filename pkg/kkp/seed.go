@@ -0,0 +1,301 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package kkp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.xrstf.de/dj/pkg/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	// SeedResource is the GVR for KKP Seeds.
+	SeedResource = schema.GroupVersionResource{
+		Group:    "kubermatic.k8c.io",
+		Version:  "v1",
+		Resource: "seeds",
+	}
+
+	// KubermaticConfigurationResource is the GVR for KKP's main configuration.
+	KubermaticConfigurationResource = schema.GroupVersionResource{
+		Group:    "kubermatic.k8c.io",
+		Version:  "v1",
+		Resource: "kubermaticconfigurations",
+	}
+)
+
+const (
+	// DefaultNamespace is the namespace KKP is installed into by default.
+	DefaultNamespace = "kubermatic"
+	// OperatorDeployment is the name of the KKP operator Deployment.
+	OperatorDeployment = "kubermatic-operator"
+	// SeedHealthyPhase is the phase of a Seed that is ready to use.
+	SeedHealthyPhase = "Healthy"
+)
+
+var (
+	// ErrNoSeed is returned when no Seed exists yet.
+	ErrNoSeed = errors.New("no KKP Seed found")
+	// ErrNoKubermaticConfiguration is returned when KKP is not configured yet.
+	ErrNoKubermaticConfiguration = errors.New("no KubermaticConfiguration found")
+)
+
+// AmbiguousSeedError is returned when multiple Seeds exist, but none of them
+// was chosen explicitly.
+type AmbiguousSeedError struct {
+	Seeds []string
+}
+
+func (e *AmbiguousSeedError) Error() string {
+	return fmt.Sprintf("found multiple Seeds (%s), use --seed to choose one", strings.Join(e.Seeds, ", "))
+}
+
+// Condition is a simplified KKP status condition.
+type Condition struct {
+	Type    string
+	Status  string
+	Reason  string
+	Message string
+}
+
+// Seed is a simplified KKP Seed.
+type Seed struct {
+	Name       string
+	Namespace  string
+	Phase      string
+	Conditions []Condition
+	// KubeconfigSecret is the Secret (in Namespace, unless
+	// KubeconfigSecretNamespace is set) containing the Seed's kubeconfig.
+	KubeconfigSecret          string
+	KubeconfigSecretNamespace string
+}
+
+// KubermaticConfiguration is a simplified KubermaticConfiguration.
+type KubermaticConfiguration struct {
+	Name       string
+	Namespace  string
+	Version    string
+	Edition    string
+	Conditions []Condition
+}
+
+// WaitForOperator waits until the KKP operator Deployment is available.
+func WaitForOperator(ctx context.Context, clientset kubernetes.Interface, namespace string) error {
+	var lastErr error
+
+	err := util.Poll(ctx, util.DefaultBackoff, func(ctx context.Context) (bool, error) {
+		lastErr = checkOperator(ctx, clientset, namespace)
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		return &NotReadyError{Resource: "KKP operator", Err: err, LastErr: lastErr}
+	}
+
+	return nil
+}
+
+func checkOperator(ctx context.Context, clientset kubernetes.Interface, namespace string) error {
+	deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, OperatorDeployment, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get operator Deployment: %w", err)
+	}
+
+	if deployment.Status.AvailableReplicas < 1 {
+		return fmt.Errorf("operator Deployment has %d available replicas", deployment.Status.AvailableReplicas)
+	}
+
+	return nil
+}
+
+// WaitForSeed waits until the given Seed (or, if no name is given, the only
+// Seed in the namespace) exists and is healthy.
+func WaitForSeed(ctx context.Context, client dynamic.Interface, namespace string, name string) (*Seed, error) {
+	var (
+		seed    *Seed
+		lastErr error
+	)
+
+	err := util.Poll(ctx, util.DefaultBackoff, func(ctx context.Context) (bool, error) {
+		seed, lastErr = getSeed(ctx, client, namespace, name)
+
+		// there is no point in waiting for the user to make a choice
+		var ambiguous *AmbiguousSeedError
+		if errors.As(lastErr, &ambiguous) {
+			return false, lastErr
+		}
+
+		if lastErr == nil && seed.Phase != SeedHealthyPhase {
+			lastErr = fmt.Errorf("seed %s is %s", seed.Name, orUnknown(seed.Phase))
+		}
+
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		var ambiguous *AmbiguousSeedError
+		if errors.As(err, &ambiguous) {
+			return nil, err
+		}
+
+		return nil, &NotReadyError{Resource: "KKP Seed", Err: err, LastErr: lastErr}
+	}
+
+	return seed, nil
+}
+
+func getSeed(ctx context.Context, client dynamic.Interface, namespace string, name string) (*Seed, error) {
+	if name != "" {
+		obj, err := client.Resource(SeedResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get Seed: %w", err)
+		}
+
+		return convertSeed(obj), nil
+	}
+
+	seeds, err := client.Resource(SeedResource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Seeds: %w", err)
+	}
+
+	switch len(seeds.Items) {
+	case 0:
+		return nil, ErrNoSeed
+	case 1:
+		return convertSeed(&seeds.Items[0]), nil
+	default:
+		names := []string{}
+		for _, seed := range seeds.Items {
+			names = append(names, seed.GetName())
+		}
+
+		return nil, &AmbiguousSeedError{Seeds: names}
+	}
+}
+
+func convertSeed(obj *unstructured.Unstructured) *Seed {
+	phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+	secret, _, _ := unstructured.NestedString(obj.Object, "spec", "kubeconfig", "name")
+	secretNamespace, _, _ := unstructured.NestedString(obj.Object, "spec", "kubeconfig", "namespace")
+
+	return &Seed{
+		Name:                      obj.GetName(),
+		Namespace:                 obj.GetNamespace(),
+		Phase:                     phase,
+		Conditions:                convertConditions(obj),
+		KubeconfigSecret:          secret,
+		KubeconfigSecretNamespace: secretNamespace,
+	}
+}
+
+// GetKubermaticConfiguration returns the KubermaticConfiguration in the given
+// namespace.
+func GetKubermaticConfiguration(ctx context.Context, client dynamic.Interface, namespace string) (*KubermaticConfiguration, error) {
+	configs, err := client.Resource(KubermaticConfigurationResource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list KubermaticConfigurations: %w", err)
+	}
+
+	if len(configs.Items) == 0 {
+		return nil, ErrNoKubermaticConfiguration
+	}
+
+	obj := configs.Items[0]
+	version, _, _ := unstructured.NestedString(obj.Object, "status", "kubermaticVersion")
+	edition, _, _ := unstructured.NestedString(obj.Object, "status", "kubermaticEdition")
+
+	return &KubermaticConfiguration{
+		Name:       obj.GetName(),
+		Namespace:  obj.GetNamespace(),
+		Version:    version,
+		Edition:    edition,
+		Conditions: convertConditions(&obj),
+	}, nil
+}
+
+// convertConditions supports both KKP's map-based conditions and regular
+// condition lists.
+func convertConditions(obj *unstructured.Unstructured) []Condition {
+	var conditions []Condition
+
+	switch raw := obj.Object["status"].(type) {
+	case map[string]any:
+		switch c := raw["conditions"].(type) {
+		case map[string]any:
+			for condType, value := range c {
+				if fields, ok := value.(map[string]any); ok {
+					conditions = append(conditions, convertCondition(condType, fields))
+				}
+			}
+
+		case []any:
+			for _, value := range c {
+				if fields, ok := value.(map[string]any); ok {
+					condType, _ := fields["type"].(string)
+					conditions = append(conditions, convertCondition(condType, fields))
+				}
+			}
+		}
+	}
+
+	sort.Slice(conditions, func(i, j int) bool {
+		return conditions[i].Type < conditions[j].Type
+	})
+
+	return conditions
+}
+
+func convertCondition(condType string, fields map[string]any) Condition {
+	status, _ := fields["status"].(string)
+	reason, _ := fields["reason"].(string)
+	message, _ := fields["message"].(string)
+
+	return Condition{
+		Type:    condType,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	}
+}
+
+// SeedKubeconfig returns the kubeconfig referenced by the Seed.
+func SeedKubeconfig(ctx context.Context, clientset kubernetes.Interface, seed *Seed) ([]byte, error) {
+	if seed.KubeconfigSecret == "" {
+		return nil, fmt.Errorf("seed %s does not reference a kubeconfig Secret", seed.Name)
+	}
+
+	namespace := seed.KubeconfigSecretNamespace
+	if namespace == "" {
+		namespace = seed.Namespace
+	}
+
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, seed.KubeconfigSecret, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Seed kubeconfig Secret: %w", err)
+	}
+
+	kubeconfig := secret.Data["kubeconfig"]
+	if len(kubeconfig) == 0 {
+		return nil, fmt.Errorf("secret %s/%s does not contain a kubeconfig", namespace, seed.KubeconfigSecret)
+	}
+
+	return kubeconfig, nil
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+
+	return s
+}
@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+// Package kkp contains helpers to access a Kubermatic Kubernetes Platform
+// (KKP) installation inside a kind cluster.
+package kkp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.xrstf.de/dj/pkg/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ClusterResource is the GVR for KKP user clusters.
+var ClusterResource = schema.GroupVersionResource{
+	Group:    "kubermatic.k8c.io",
+	Version:  "v1",
+	Resource: "clusters",
+}
+
+const (
+	// AdminKubeconfigSecret is the Secret in the cluster namespace that
+	// contains the admin kubeconfig for a user cluster.
+	AdminKubeconfigSecret = "admin-kubeconfig"
+)
+
+var (
+	// ErrNoUserCluster is returned when no user cluster exists yet.
+	ErrNoUserCluster = errors.New("no KKP user cluster found")
+	// ErrNoClusterNamespace is returned when a user cluster has no namespace yet.
+	ErrNoClusterNamespace = errors.New("user cluster has no namespace yet")
+	// ErrNoKubeconfig is returned when a user cluster has no kubeconfig yet.
+	ErrNoKubeconfig = errors.New("user cluster has no kubeconfig yet")
+)
+
+// NotReadyError is returned when waiting for a KKP resource was aborted,
+// usually because the context was cancelled or timed out.
+type NotReadyError struct {
+	// Resource describes what was being waited for.
+	Resource string
+	// Err is the reason why waiting was aborted.
+	Err error
+	// LastErr is the last error encountered while checking the resource.
+	LastErr error
+}
+
+func (e *NotReadyError) Error() string {
+	msg := fmt.Sprintf("%s did not become ready: %v", e.Resource, e.Err)
+	if e.LastErr != nil {
+		msg = fmt.Sprintf("%s (last error: %v)", msg, e.LastErr)
+	}
+
+	return msg
+}
+
+func (e *NotReadyError) Unwrap() error {
+	return e.Err
+}
+
+// UserCluster identifies a KKP user cluster.
+type UserCluster struct {
+	Name string
+	// Namespace is the cluster namespace on the seed cluster.
+	Namespace string
+}
+
+// WaitForUserCluster waits for the first user cluster to be created and to
+// have a cluster namespace.
+func WaitForUserCluster(ctx context.Context, client dynamic.Interface) (*UserCluster, error) {
+	var (
+		cluster *UserCluster
+		lastErr error
+	)
+
+	err := util.Poll(ctx, util.DefaultBackoff, func(ctx context.Context) (bool, error) {
+		cluster, lastErr = getUserCluster(ctx, client)
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		return nil, &NotReadyError{Resource: "KKP user cluster", Err: err, LastErr: lastErr}
+	}
+
+	return cluster, nil
+}
+
+func getUserCluster(ctx context.Context, client dynamic.Interface) (*UserCluster, error) {
+	clusters, err := client.Resource(ClusterResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user clusters: %w", err)
+	}
+
+	if len(clusters.Items) == 0 {
+		return nil, ErrNoUserCluster
+	}
+
+	cluster := clusters.Items[0]
+
+	namespace, _, err := unstructured.NestedString(cluster.Object, "status", "namespaceName")
+	if err != nil {
+		return nil, fmt.Errorf("invalid user cluster %s: %w", cluster.GetName(), err)
+	}
+
+	if namespace == "" {
+		return nil, ErrNoClusterNamespace
+	}
+
+	return &UserCluster{
+		Name:      cluster.GetName(),
+		Namespace: namespace,
+	}, nil
+}
+
+// WaitForKubeconfig waits for the admin kubeconfig of the given user cluster
+// to be created and returns it.
+func WaitForKubeconfig(ctx context.Context, clientset kubernetes.Interface, cluster *UserCluster) ([]byte, error) {
+	var (
+		kubeconfig []byte
+		lastErr    error
+	)
+
+	err := util.Poll(ctx, util.DefaultBackoff, func(ctx context.Context) (bool, error) {
+		kubeconfig, lastErr = getKubeconfig(ctx, clientset, cluster)
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		return nil, &NotReadyError{Resource: fmt.Sprintf("kubeconfig for KKP user cluster %s", cluster.Name), Err: err, LastErr: lastErr}
+	}
+
+	return kubeconfig, nil
+}
+
+func getKubeconfig(ctx context.Context, clientset kubernetes.Interface, cluster *UserCluster) ([]byte, error) {
+	secret, err := clientset.CoreV1().Secrets(cluster.Namespace).Get(ctx, AdminKubeconfigSecret, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kubeconfig Secret: %w", err)
+	}
+
+	kubeconfig := secret.Data["kubeconfig"]
+	if len(kubeconfig) == 0 {
+		return nil, ErrNoKubeconfig
+	}
+
+	return kubeconfig, nil
+}
@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package logfilter
+
+import (
+	"regexp"
+)
+
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+)
+
+var highlights = []struct {
+	expr  *regexp.Regexp
+	color string
+}{
+	{
+		expr:  regexp.MustCompile(`\[(FAILED|PANICKED|TIMEDOUT|INTERRUPTED)\]|\bFAIL(ED\b|!|\b)|\bERROR\b|"level":"error"|\bpanic:`),
+		color: colorRed,
+	},
+	{
+		expr:  regexp.MustCompile(`\bWARN(ING)?\b|"level":"warn(ing)?"`),
+		color: colorYellow,
+	},
+	{
+		expr:  regexp.MustCompile(`\[PASSED\]|\bPASS(ED\b|!|\b)|^ok\s`),
+		color: colorGreen,
+	},
+}
+
+func colorize(line string) string {
+	for _, h := range highlights {
+		line = h.expr.ReplaceAllStringFunc(line, func(match string) string {
+			return h.color + match + colorReset
+		})
+	}
+
+	return line
+}
@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package logfilter
+
+import (
+	"regexp"
+	"strings"
+)
+
+// maxBufferedLines limits how many lines of a single test block are kept
+// in memory; for very long blocks only the most recent lines are shown.
+const maxBufferedLines = 5000
+
+var (
+	// Ginkgo separates specs using long lines of dashes.
+	ginkgoSeparator = regexp.MustCompile(`^-{20,}$`)
+	ginkgoFailure   = regexp.MustCompile(`\[(FAILED|PANICKED|TIMEDOUT|INTERRUPTED)\]`)
+
+	// lines that are always shown, as they summarize the test results
+	summaryLine = regexp.MustCompile(`^(FAIL\b|FAIL!|ok\s*\t|panic:|Summarizing \d+ Failures?|Ran \d+ of \d+ Specs)`)
+)
+
+// failureFilter buffers the lines of the currently running Go test or Ginkgo
+// spec and only emits them once the test/spec is known to have failed.
+type failureFilter struct {
+	buf []string
+	// true while the indented output following a "--- FAIL" line is emitted
+	inGoFailure bool
+}
+
+func (f *failureFilter) process(line string) []string {
+	trimmed := strings.TrimSpace(line)
+
+	// output of a failed Go test follows its "--- FAIL" line, indented
+	if f.inGoFailure {
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			return []string{line}
+		}
+
+		f.inGoFailure = false
+	}
+
+	switch {
+	case ginkgoSeparator.MatchString(trimmed):
+		block := f.buf
+		f.buf = nil
+
+		if containsMatch(block, ginkgoFailure) {
+			return append(append([]string{line}, block...), line)
+		}
+
+		return nil
+
+	case strings.HasPrefix(trimmed, "=== RUN") || strings.HasPrefix(trimmed, "=== CONT"):
+		f.buf = []string{line}
+		return nil
+
+	case strings.HasPrefix(trimmed, "--- FAIL:"):
+		block := append(f.buf, line)
+		f.buf = nil
+		f.inGoFailure = true
+
+		return block
+
+	case strings.HasPrefix(trimmed, "--- PASS:") || strings.HasPrefix(trimmed, "--- SKIP:"):
+		f.buf = nil
+		return nil
+
+	case summaryLine.MatchString(trimmed):
+		return []string{line}
+	}
+
+	f.buf = append(f.buf, line)
+	if len(f.buf) > maxBufferedLines {
+		f.buf = f.buf[len(f.buf)-maxBufferedLines:]
+	}
+
+	return nil
+}
+
+func containsMatch(lines []string, expr *regexp.Regexp) bool {
+	for _, line := range lines {
+		if expr.MatchString(line) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package logfilter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelNone Level = iota
+	LevelTrace
+	LevelDebug
+	LevelInfo
+	LevelWarning
+	LevelError
+	LevelFatal
+)
+
+// ParseLevel parses the level names used by logrus and zap.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return LevelNone, nil
+	case "trace":
+		return LevelTrace, nil
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarning, nil
+	case "error":
+		return LevelError, nil
+	case "dpanic", "panic", "fatal":
+		return LevelFatal, nil
+	default:
+		return LevelNone, fmt.Errorf("unknown log level %q", s)
+	}
+}
+
+// levelKeys are the JSON keys commonly used for the log level.
+var levelKeys = []string{"level", "lvl", "severity"}
+
+// lineLevel returns the level of a JSON log line, or LevelNone if the line
+// is not JSON or has no (known) level.
+func lineLevel(line string) Level {
+	line = strings.TrimSpace(line)
+
+	// lines might be prefixed with the Kubernetes log timestamp
+	if prefix, rest, found := strings.Cut(line, " "); found {
+		if _, err := time.Parse(time.RFC3339Nano, prefix); err == nil {
+			line = rest
+		}
+	}
+
+	if !strings.HasPrefix(line, "{") {
+		return LevelNone
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return LevelNone
+	}
+
+	for _, key := range levelKeys {
+		if value, ok := fields[key].(string); ok {
+			level, err := ParseLevel(value)
+			if err == nil {
+				return level
+			}
+		}
+	}
+
+	return LevelNone
+}
@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+// Package logfilter implements line-based filtering and highlighting for
+// e2e test logs, which are typically a mix of Ginkgo output, `go test`
+// output and structured JSON log lines.
+package logfilter
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+)
+
+type Options struct {
+	// Grep, if set, only lets lines pass that match the expression.
+	Grep *regexp.Regexp
+	// Exclude, if set, drops all lines that match the expression.
+	Exclude *regexp.Regexp
+	// MinLevel, if set, drops all JSON log lines with a lower level.
+	// Lines that are not JSON or have no level are not affected.
+	MinLevel Level
+	// FailuresOnly only lets failed Ginkgo specs and Go tests pass.
+	FailuresOnly bool
+	// Colorize highlights FAIL/PASS/ERROR markers using ANSI colors.
+	Colorize bool
+}
+
+// Writer applies the configured filters to every line written to it and
+// writes the remaining lines to the underlying writer. Incomplete lines are
+// buffered until they are completed or Flush is called.
+type Writer struct {
+	out      io.Writer
+	opts     Options
+	buf      []byte
+	failures *failureFilter
+}
+
+func NewWriter(out io.Writer, opts Options) *Writer {
+	w := &Writer{
+		out:  out,
+		opts: opts,
+	}
+
+	if opts.FailuresOnly {
+		w.failures = &failureFilter{}
+	}
+
+	return w
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx < 0 {
+			break
+		}
+
+		line := string(w.buf[:idx])
+		w.buf = w.buf[idx+1:]
+
+		if err := w.processLine(line); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush processes a trailing incomplete line.
+func (w *Writer) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	line := string(w.buf)
+	w.buf = nil
+
+	return w.processLine(line)
+}
+
+func (w *Writer) processLine(line string) error {
+	// the failures filter needs to see all lines to detect test blocks, so
+	// the line filters are only applied to what it lets pass
+	lines := []string{line}
+	if w.failures != nil {
+		lines = w.failures.process(line)
+	}
+
+	var out bytes.Buffer
+	for _, l := range lines {
+		if !w.matches(l) {
+			continue
+		}
+
+		if w.opts.Colorize {
+			l = colorize(l)
+		}
+
+		out.WriteString(l)
+		out.WriteByte('\n')
+	}
+
+	if out.Len() == 0 {
+		return nil
+	}
+
+	_, err := w.out.Write(out.Bytes())
+
+	return err
+}
+
+func (w *Writer) matches(line string) bool {
+	if w.opts.Grep != nil && !w.opts.Grep.MatchString(line) {
+		return false
+	}
+
+	if w.opts.Exclude != nil && w.opts.Exclude.MatchString(line) {
+		return false
+	}
+
+	if w.opts.MinLevel != LevelNone {
+		if level := lineLevel(line); level != LevelNone && level < w.opts.MinLevel {
+			return false
+		}
+	}
+
+	return true
+}
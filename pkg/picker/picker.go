@@ -0,0 +1,278 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+// Package picker implements a minimal interactive, fuzzy-filtering list
+// selector for terminals.
+package picker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+var ErrAborted = errors.New("selection aborted")
+
+const maxVisibleItems = 15
+
+// IsTerminal returns true if both the given input and output are terminals.
+func IsTerminal(in *os.File, out *os.File) bool {
+	return term.IsTerminal(int(in.Fd())) && term.IsTerminal(int(out.Fd()))
+}
+
+// Pick shows the given items and lets the user filter and select one of them.
+// The index of the selected item is returned. The header is shown above the
+// items and is not subject to filtering. When the user aborts the selection,
+// ErrAborted is returned.
+func Pick(in *os.File, out *os.File, prompt string, header string, items []string) (int, error) {
+	if len(items) == 0 {
+		return -1, errors.New("nothing to select from")
+	}
+
+	oldState, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return -1, fmt.Errorf("failed to enable raw terminal mode: %w", err)
+	}
+	defer func() {
+		_ = term.Restore(int(in.Fd()), oldState)
+	}()
+
+	p := &picker{
+		out:     out,
+		width:   terminalWidth(out),
+		prompt:  prompt,
+		header:  header,
+		items:   items,
+		matches: allIndices(len(items)),
+	}
+
+	defer p.clear()
+
+	buf := make([]byte, 16)
+	for {
+		p.render()
+
+		n, err := in.Read(buf)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return -1, ErrAborted
+			}
+
+			return -1, err
+		}
+
+		done, err := p.handleInput(buf[:n])
+		if err != nil {
+			return -1, err
+		}
+
+		if done {
+			return p.matches[p.cursor], nil
+		}
+	}
+}
+
+type picker struct {
+	out      io.Writer
+	width    int
+	prompt   string
+	header   string
+	items    []string
+	query    string
+	matches  []int
+	cursor   int
+	rendered int
+}
+
+func (p *picker) handleInput(input []byte) (bool, error) {
+	switch string(input) {
+	case "\r", "\n":
+		if len(p.matches) == 0 {
+			return false, nil
+		}
+
+		return true, nil
+
+	case "\x03", "\x1b": // Ctrl-C, Esc
+		return false, ErrAborted
+
+	case "\x1b[A", "\x1bOA", "\x10", "\x0b": // up, Ctrl-P, Ctrl-K
+		if p.cursor > 0 {
+			p.cursor--
+		}
+
+	case "\x1b[B", "\x1bOB", "\x0e", "\t": // down, Ctrl-N, Tab
+		if p.cursor < len(p.matches)-1 {
+			p.cursor++
+		}
+
+	case "\x7f", "\x08": // Backspace
+		if p.query != "" {
+			_, size := utf8.DecodeLastRuneInString(p.query)
+			p.setQuery(p.query[:len(p.query)-size])
+		}
+
+	case "\x15": // Ctrl-U
+		p.setQuery("")
+
+	default:
+		// ignore all other control sequences
+		if input[0] == '\x1b' {
+			return false, nil
+		}
+
+		typed := strings.Map(func(r rune) rune {
+			if unicode.IsPrint(r) {
+				return r
+			}
+
+			return -1
+		}, string(input))
+
+		if typed != "" {
+			p.setQuery(p.query + typed)
+		}
+	}
+
+	return false, nil
+}
+
+func (p *picker) setQuery(query string) {
+	p.query = query
+	p.cursor = 0
+	p.matches = p.matches[:0]
+
+	terms := strings.Fields(strings.ToLower(query))
+
+	for idx, item := range p.items {
+		if matchesAll(strings.ToLower(item), terms) {
+			p.matches = append(p.matches, idx)
+		}
+	}
+}
+
+func (p *picker) render() {
+	var b strings.Builder
+
+	p.clearInto(&b)
+
+	lines := []string{
+		fmt.Sprintf("%s %s", p.prompt, p.query),
+		fmt.Sprintf("  %d/%d", len(p.matches), len(p.items)),
+	}
+
+	if p.header != "" {
+		lines = append(lines, "  "+p.header)
+	}
+
+	// scroll the visible window so that the cursor is always visible
+	offset := 0
+	if p.cursor >= maxVisibleItems {
+		offset = p.cursor - maxVisibleItems + 1
+	}
+
+	for i := offset; i < len(p.matches) && i < offset+maxVisibleItems; i++ {
+		marker := "  "
+		if i == p.cursor {
+			marker = "> "
+		}
+
+		lines = append(lines, marker+p.items[p.matches[i]])
+	}
+
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+
+		b.WriteString(truncate(line, p.width))
+	}
+
+	// move the cursor back to the end of the prompt line
+	if len(lines) > 1 {
+		fmt.Fprintf(&b, "\x1b[%dA", len(lines)-1)
+	}
+
+	fmt.Fprintf(&b, "\r\x1b[%dC", utf8.RuneCountInString(lines[0]))
+
+	p.rendered = len(lines)
+
+	_, _ = io.WriteString(p.out, b.String())
+}
+
+func (p *picker) clear() {
+	var b strings.Builder
+	p.clearInto(&b)
+
+	_, _ = io.WriteString(p.out, b.String())
+}
+
+func (p *picker) clearInto(b *strings.Builder) {
+	if p.rendered > 0 {
+		// clear from the beginning of the prompt line to the end of the screen
+		b.WriteString("\r\x1b[J")
+	}
+}
+
+// matchesAll returns true if every term is a fuzzy match for the item,
+// i.e. all characters of the term appear in the item in the same order.
+func matchesAll(item string, terms []string) bool {
+	for _, term := range terms {
+		if !fuzzyMatch(item, term) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func fuzzyMatch(item string, term string) bool {
+	remaining := term
+
+	for _, r := range item {
+		if remaining == "" {
+			break
+		}
+
+		next, size := utf8.DecodeRuneInString(remaining)
+		if r == next {
+			remaining = remaining[size:]
+		}
+	}
+
+	return remaining == ""
+}
+
+func truncate(s string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+
+	runes := []rune(s)
+
+	return string(runes[:width-1]) + "…"
+}
+
+func terminalWidth(out *os.File) int {
+	width, _, err := term.GetSize(int(out.Fd()))
+	if err != nil {
+		return 0
+	}
+
+	return width
+}
+
+func allIndices(n int) []int {
+	indices := make([]int, n)
+	for i := range indices {
+		indices[i] = i
+	}
+
+	return indices
+}
@@ -5,4 +5,17 @@ package prow
 
 const (
 	TestContainerName = "test"
+
+	BuildIDLabel  = "prow.k8s.io/build-id"
+	JobIDLabel    = "prow.k8s.io/id"
+	JobNameLabel  = "prow.k8s.io/job"
+	OrgLabel      = "prow.k8s.io/refs.org"
+	RepoLabel     = "prow.k8s.io/refs.repo"
+	PullLabel     = "prow.k8s.io/refs.pull"
+	JobTypeLabel  = "prow.k8s.io/type"
+	CreatedByProw = "created-by-prow"
+
+	// JobNameAnnotation contains the full job name, as the label value might
+	// be truncated.
+	JobNameAnnotation = "prow.k8s.io/job"
 )
@@ -0,0 +1,174 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package prow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	JobStatePending   = "pending"
+	JobStateRunning   = "running"
+	JobStateSucceeded = "succeeded"
+	JobStateFailed    = "failed"
+)
+
+// Job is a summary of a Prow job, as far as it can be determined by
+// looking at the job's Pod.
+type Job struct {
+	Name        string    `json:"name"`
+	Type        string    `json:"type,omitempty"`
+	BuildID     string    `json:"buildID"`
+	JobID       string    `json:"jobID"`
+	Org         string    `json:"org,omitempty"`
+	Repo        string    `json:"repo,omitempty"`
+	Pull        string    `json:"pull,omitempty"`
+	Author      string    `json:"author,omitempty"`
+	State       string    `json:"state"`
+	StateDetail string    `json:"stateDetail,omitempty"`
+	Pod         string    `json:"pod"`
+	Node        string    `json:"node,omitempty"`
+	Created     time.Time `json:"created"`
+}
+
+// jobSpec is the subset of Prow's JobSpec that is injected into decorated
+// Pods via the JOB_SPEC environment variable.
+type jobSpec struct {
+	Refs *struct {
+		Pulls []struct {
+			Number int    `json:"number"`
+			Author string `json:"author"`
+		} `json:"pulls"`
+	} `json:"refs"`
+}
+
+// ListJobs returns all Prow jobs in the given namespace, newest first. The
+// label selector is optional and can be used to narrow down the result.
+func ListJobs(ctx context.Context, clientset *kubernetes.Clientset, namespace string, selector string) ([]Job, error) {
+	selectors := []string{fmt.Sprintf("%s=true", CreatedByProw)}
+	if selector != "" {
+		selectors = append(selectors, selector)
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: strings.Join(selectors, ","),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Pods: %w", err)
+	}
+
+	jobs := make([]Job, 0, len(pods.Items))
+	for i := range pods.Items {
+		jobs = append(jobs, NewJob(&pods.Items[i]))
+	}
+
+	slices.SortFunc(jobs, func(a, b Job) int {
+		return b.Created.Compare(a.Created)
+	})
+
+	return jobs, nil
+}
+
+func NewJob(pod *corev1.Pod) Job {
+	job := Job{
+		Name:    pod.Labels[JobNameLabel],
+		Type:    pod.Labels[JobTypeLabel],
+		BuildID: pod.Labels[BuildIDLabel],
+		JobID:   pod.Labels[JobIDLabel],
+		Org:     pod.Labels[OrgLabel],
+		Repo:    pod.Labels[RepoLabel],
+		Pull:    pod.Labels[PullLabel],
+		Pod:     pod.Name,
+		Node:    pod.Spec.NodeName,
+		Created: pod.CreationTimestamp.Time,
+	}
+
+	// the label might be truncated
+	if name := pod.Annotations[JobNameAnnotation]; name != "" {
+		job.Name = name
+	}
+
+	job.Author = podAuthor(pod)
+	job.State, job.StateDetail = TestContainerState(pod)
+
+	return job
+}
+
+// TestContainerState returns a short state and optional details about the
+// test container in the given Pod.
+func TestContainerState(pod *corev1.Pod) (string, string) {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != TestContainerName {
+			continue
+		}
+
+		switch {
+		case status.State.Running != nil:
+			return JobStateRunning, ""
+
+		case status.State.Terminated != nil:
+			terminated := status.State.Terminated
+			detail := fmt.Sprintf("exit code %d", terminated.ExitCode)
+			if terminated.Reason != "" {
+				detail = fmt.Sprintf("%s, %s", terminated.Reason, detail)
+			}
+
+			if terminated.ExitCode == 0 {
+				return JobStateSucceeded, detail
+			}
+
+			return JobStateFailed, detail
+
+		case status.State.Waiting != nil:
+			return JobStatePending, status.State.Waiting.Reason
+		}
+	}
+
+	// container has no status yet
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded:
+		return JobStateSucceeded, ""
+	case corev1.PodFailed:
+		return JobStateFailed, pod.Status.Reason
+	default:
+		return JobStatePending, string(pod.Status.Phase)
+	}
+}
+
+func podAuthor(pod *corev1.Pod) string {
+	for _, container := range pod.Spec.Containers {
+		if container.Name != TestContainerName {
+			continue
+		}
+
+		for _, env := range container.Env {
+			if env.Name != "JOB_SPEC" {
+				continue
+			}
+
+			spec := jobSpec{}
+			if err := json.Unmarshal([]byte(env.Value), &spec); err != nil || spec.Refs == nil {
+				return ""
+			}
+
+			var authors []string
+			for _, pull := range spec.Refs.Pulls {
+				authors = append(authors, pull.Author)
+			}
+
+			return strings.Join(authors, ",")
+		}
+	}
+
+	return ""
+}
@@ -5,23 +5,59 @@ package prow
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
 )
 
+var ErrNoMatchingPod = errors.New("no Pod matches")
+
 type PodIdentifier struct {
 	BuildID string
 	JobID   string
+	JobName string
+	Org     string
+	Repo    string
+	Pull    string
+
+	// PodName can be used to pick a specific Pod if multiple
+	// Pods match the other criteria.
+	PodName string
 }
 
+var (
+	jobNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+	orgRepoRegex = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+)
+
+// ParsePodIdentifier parses a user-provided job identifier. Supported are
+//
+//   - job IDs (UUIDs, equal to the Pod name),
+//   - build IDs (64-bit integers),
+//   - job names, optionally with a PR number (JOB_NAME[#PR]),
+//   - repositories with a PR number (ORG/REPO#PR) and
+//   - Deck, Spyglass and storage URLs (see parseURL).
 func ParsePodIdentifier(arg string) (*PodIdentifier, error) {
+	if looksLikeURL(arg) {
+		return parseURL(arg)
+	}
+
 	// is it a UUID?
 	parsed, err := uuid.Parse(arg)
 	if err == nil {
@@ -33,19 +69,173 @@ func ParsePodIdentifier(arg string) (*PodIdentifier, error) {
 		return &PodIdentifier{BuildID: arg}, nil
 	}
 
-	return nil, fmt.Errorf("%q is neither a UUID nor a valid 64-bit build ID", arg)
+	// last chance: JOB_NAME[#PR] or ORG/REPO#PR
+	name, pull, hasPull := strings.Cut(arg, "#")
+
+	ident := &PodIdentifier{}
+
+	if hasPull {
+		if _, err := strconv.ParseUint(pull, 10, 32); err != nil {
+			return nil, fmt.Errorf("%q is not a valid PR number", pull)
+		}
+
+		ident.Pull = pull
+	}
+
+	if org, repo, isRepo := strings.Cut(name, "/"); isRepo {
+		if !hasPull {
+			return nil, fmt.Errorf("%q must be given with a PR number (ORG/REPO#PR)", arg)
+		}
+
+		if !orgRepoRegex.MatchString(org) || !orgRepoRegex.MatchString(repo) {
+			return nil, fmt.Errorf("%q is not a valid repository", name)
+		}
+
+		ident.Org = org
+		ident.Repo = repo
+
+		return ident, nil
+	}
+
+	if !jobNameRegex.MatchString(name) {
+		return nil, fmt.Errorf("%q is neither a UUID, a valid 64-bit build ID nor a job name", arg)
+	}
+
+	ident.JobName = name
+
+	return ident, nil
 }
 
 func (i *PodIdentifier) LabelSelector() string {
+	var selectors []string
+
+	if i.BuildID != "" {
+		selectors = append(selectors, fmt.Sprintf("%s=%s", BuildIDLabel, i.BuildID))
+	}
+
+	if i.JobID != "" {
+		selectors = append(selectors, fmt.Sprintf("%s=%s", JobIDLabel, i.JobID))
+	}
+
+	// job names longer than 63 characters are truncated by Prow, in which
+	// case we can only compare them client-side (see Matches())
+	if i.JobName != "" && len(validation.IsValidLabelValue(i.JobName)) == 0 {
+		selectors = append(selectors, fmt.Sprintf("%s=%s", JobNameLabel, i.JobName))
+	}
+
+	if i.Org != "" {
+		selectors = append(selectors, fmt.Sprintf("%s=%s", OrgLabel, i.Org))
+	}
+
+	if i.Repo != "" {
+		selectors = append(selectors, fmt.Sprintf("%s=%s", RepoLabel, i.Repo))
+	}
+
+	if i.Pull != "" {
+		selectors = append(selectors, fmt.Sprintf("%s=%s", PullLabel, i.Pull))
+	}
+
+	return strings.Join(selectors, ",")
+}
+
+// Matches performs the checks that cannot be expressed using a label selector.
+func (i *PodIdentifier) Matches(pod *corev1.Pod) bool {
+	if i.PodName != "" && pod.Name != i.PodName {
+		return false
+	}
+
+	if i.JobName != "" && pod.Annotations[JobNameAnnotation] != "" {
+		return pod.Annotations[JobNameAnnotation] == i.JobName
+	}
+
+	return true
+}
+
+// IsUnique returns true if the identifier points to a single job run.
+func (i *PodIdentifier) IsUnique() bool {
+	return i.BuildID != "" || i.JobID != ""
+}
+
+// Resolve turns a non-unique identifier (e.g. a job name) into a unique one by
+// finding the most recent matching Pod and using its build ID. If multiple job
+// runs match, a warning is logged.
+func (i *PodIdentifier) Resolve(ctx context.Context, logger logrus.FieldLogger, clientset *kubernetes.Clientset, namespace string) error {
+	if i.IsUnique() {
+		return nil
+	}
+
+	candidates, err := i.FindPods(ctx, clientset, namespace)
+	if err != nil {
+		return err
+	}
+
+	if len(candidates) == 0 {
+		return fmt.Errorf("%w %s", ErrNoMatchingPod, i)
+	}
+
+	newest := candidates[0]
+
+	if len(candidates) > 1 {
+		logger.Warnf("%d job runs match %s, using the most recent one (%s).", len(candidates), i, PodName(&newest))
+
+		for _, pod := range candidates {
+			logger.WithField("build", pod.Labels[BuildIDLabel]).WithField("created", pod.CreationTimestamp.Format(time.RFC3339)).Debug("Candidate")
+		}
+	}
+
+	i.BuildID = newest.Labels[BuildIDLabel]
+
+	return nil
+}
+
+// FindPods returns all Prow job Pods that currently match the identifier,
+// newest first.
+func (i *PodIdentifier) FindPods(ctx context.Context, clientset *kubernetes.Clientset, namespace string) ([]corev1.Pod, error) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: i.LabelSelector(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Pods: %w", err)
+	}
+
+	var matching []corev1.Pod
+	for _, pod := range pods.Items {
+		if i.Matches(&pod) && pod.Labels[BuildIDLabel] != "" {
+			matching = append(matching, pod)
+		}
+	}
+
+	slices.SortFunc(matching, func(a, b corev1.Pod) int {
+		return b.CreationTimestamp.Compare(a.CreationTimestamp.Time)
+	})
+
+	return matching, nil
+}
+
+func (i *PodIdentifier) String() string {
+	var parts []string
+
+	if i.JobName != "" {
+		parts = append(parts, fmt.Sprintf("job %s", i.JobName))
+	}
+
+	if i.Org != "" || i.Repo != "" {
+		parts = append(parts, fmt.Sprintf("repository %s/%s", i.Org, i.Repo))
+	}
+
+	if i.Pull != "" {
+		parts = append(parts, fmt.Sprintf("PR #%s", i.Pull))
+	}
+
 	if i.BuildID != "" {
-		return fmt.Sprintf("prow.k8s.io/build-id=%s", i.BuildID)
+		parts = append(parts, fmt.Sprintf("build %s", i.BuildID))
 	}
 
 	if i.JobID != "" {
-		return fmt.Sprintf("prow.k8s.io/id=%s", i.JobID)
+		parts = append(parts, fmt.Sprintf("job ID %s", i.JobID))
 	}
 
-	return ""
+	return strings.Join(parts, ", ")
 }
 
 func (i *PodIdentifier) Fields() logrus.Fields {
@@ -58,53 +248,294 @@ func (i *PodIdentifier) Fields() logrus.Fields {
 		fields["job"] = i.JobID
 	}
 
+	if i.PodName != "" {
+		fields["pod"] = i.PodName
+	}
+
+	if i.JobName != "" {
+		fields["jobname"] = i.JobName
+	}
+
+	if i.Org != "" {
+		fields["repo"] = fmt.Sprintf("%s/%s", i.Org, i.Repo)
+	}
+
+	if i.Pull != "" {
+		fields["pr"] = i.Pull
+	}
+
 	return fields
 }
 
-type PodCheckerFunc func(pod *corev1.Pod) bool
+// PodName returns a short, human readable name for the given Prow job Pod,
+// preferring the build ID over the job ID.
+func PodName(pod *corev1.Pod) string {
+	if buildID := pod.Labels[BuildIDLabel]; buildID != "" {
+		return buildID
+	}
 
-func (i *PodIdentifier) WaitForPod(ctx context.Context, clientset *kubernetes.Clientset, namespace string, validPod PodCheckerFunc, giveUp PodCheckerFunc) (*corev1.Pod, error) {
-	wi, err := clientset.CoreV1().Pods(namespace).Watch(ctx, metav1.ListOptions{
-		LabelSelector: i.LabelSelector(),
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to watch Pods: %w", err)
+	if jobID := pod.Labels[JobIDLabel]; jobID != "" {
+		return jobID
 	}
 
+	return pod.Name
+}
+
+type PodCheckerFunc func(pod *corev1.Pod) bool
+
+// progressInterval is the interval in which WaitForPod reminds the user
+// that it's still waiting.
+const progressInterval = 30 * time.Second
+
+// errWatchExpired signals that the watch's resource version is too old and
+// the Pods need to be listed again.
+var errWatchExpired = errors.New("watch expired")
+
+// WaitForPod waits until a Pod matching the identifier satisfies validPod. If
+// giveUp returns true for a Pod, nil is returned without an error. Progress
+// messages describe the given containers (all containers if none are given).
+// All other
+// reasons for not finding a Pod (including context cancellation) are returned
+// as errors.
+//
+// Pods are listed first and then watched starting at the list's resource version.
+// Closed watches are transparently resumed, expired watches lead to re-listing.
+func (i *PodIdentifier) WaitForPod(ctx context.Context, logger logrus.FieldLogger, clientset *kubernetes.Clientset, namespace string, containers []string, validPod PodCheckerFunc, giveUp PodCheckerFunc) (*corev1.Pod, error) {
 	if giveUp == nil {
 		giveUp = func(_ *corev1.Pod) bool {
 			return false
 		}
 	}
 
-	var pod *corev1.Pod
+	w := &podWaiter{
+		ident:      i,
+		logger:     logger,
+		validPod:   validPod,
+		giveUp:     giveUp,
+		containers: containers,
+		started:    time.Now(),
+		progress:   "no Pod has been created yet",
+	}
+
+	pods := clientset.CoreV1().Pods(namespace)
+	selector := i.LabelSelector()
+
+	lw := &cache.ListWatch{
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			opts.LabelSelector = selector
+			return pods.Watch(ctx, opts)
+		},
+	}
+
+	for {
+		list, err := pods.List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Pods: %w", err)
+		}
+
+		for idx := range list.Items {
+			if pod, done := w.check(&list.Items[idx]); done {
+				return pod, nil
+			}
+		}
 
-	for event := range wi.ResultChan() {
-		if pod != nil {
+		watcher, err := watchtools.NewRetryWatcher(list.ResourceVersion, lw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to watch Pods: %w", err)
+		}
+
+		pod, err := w.watch(ctx, watcher)
+		if errors.Is(err, errWatchExpired) {
+			logger.Debug("Watch has expired, listing Pods again…")
 			continue
 		}
 
-		var ok bool
-		pod, ok = event.Object.(*corev1.Pod)
-		if !ok {
-			pod = nil
+		return pod, err
+	}
+}
+
+type podWaiter struct {
+	ident      *PodIdentifier
+	logger     logrus.FieldLogger
+	validPod   PodCheckerFunc
+	giveUp     PodCheckerFunc
+	containers []string
+	started    time.Time
+	progress   string
+}
+
+func (w *podWaiter) watch(ctx context.Context, watcher *watchtools.RetryWatcher) (*corev1.Pod, error) {
+	defer watcher.Stop()
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		case <-ticker.C:
+			w.logger.Infof("Still waiting after %v: %s.", time.Since(w.started).Round(time.Second), w.progress)
+
+		case event, ok := <-watcher.ResultChan():
+			// the RetryWatcher only stops by itself after it encountered
+			// an unrecoverable error, like an expired resource version
+			if !ok {
+				return nil, errWatchExpired
+			}
+
+			switch event.Type {
+			case watch.Error:
+				err := apierrors.FromObject(event.Object)
+				if apierrors.IsGone(err) || apierrors.IsResourceExpired(err) {
+					return nil, errWatchExpired
+				}
+
+				return nil, fmt.Errorf("failed to watch Pods: %w", err)
+
+			case watch.Deleted:
+				if pod, ok := event.Object.(*corev1.Pod); ok && w.ident.Matches(pod) {
+					w.setProgress(pod, "Pod has been deleted")
+				}
+
+			default:
+				pod, ok := event.Object.(*corev1.Pod)
+				if !ok {
+					continue
+				}
+
+				if pod, done := w.check(pod); done {
+					return pod, nil
+				}
+			}
+		}
+	}
+}
+
+// check returns true if the waiting is over, either because the Pod is valid
+// or because the waiting should be given up. In the latter case, the returned
+// Pod is nil.
+func (w *podWaiter) check(pod *corev1.Pod) (*corev1.Pod, bool) {
+	if !w.ident.Matches(pod) {
+		return nil, false
+	}
+
+	if w.validPod(pod) {
+		return pod, true
+	}
+
+	if w.giveUp(pod) {
+		return nil, true
+	}
+
+	w.setProgress(pod, DescribePodProgress(pod, w.containers))
+
+	return nil, false
+}
+
+func (w *podWaiter) setProgress(pod *corev1.Pod, progress string) {
+	if progress == w.progress {
+		return
+	}
+
+	w.progress = progress
+	w.logger.WithField("node", pod.Spec.NodeName).Infof("Pod is not ready yet: %s.", progress)
+}
+
+// DescribePodProgress returns a human readable description of what is
+// currently happening with a Prow job Pod that is not yet running. Only the
+// given containers are described (all containers if none are given).
+func DescribePodProgress(pod *corev1.Pod, containers []string) string {
+	if pod.DeletionTimestamp != nil {
+		return "Pod is being deleted"
+	}
+
+	if pod.Spec.NodeName == "" {
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse && cond.Message != "" {
+				return fmt.Sprintf("Pod cannot be scheduled yet (%s)", cond.Message)
+			}
+		}
+
+		return "Pod is waiting to be scheduled"
+	}
+
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.State.Terminated != nil {
 			continue
 		}
 
-		if !validPod(pod) && !giveUp(pod) {
-			pod = nil
+		return fmt.Sprintf("init container %q is %s", status.Name, describeContainerState(status.State))
+	}
+
+	names := containers
+	if len(names) == 0 {
+		for _, container := range pod.Spec.Containers {
+			names = append(names, container.Name)
+		}
+	}
+
+	// report the first selected container that is not running yet
+	described := ""
+	for _, name := range names {
+		state, ok := containerState(pod, name)
+		if !ok {
 			continue
 		}
 
-		// we found what we were looking for, stop generating new events
-		wi.Stop()
+		description := fmt.Sprintf("container %q is %s", name, describeContainerState(state))
+		if state.Running == nil {
+			return description
+		}
 
-		// let the loop finish, in case there are more events (that we will ignore)
+		if described == "" {
+			described = description
+		}
 	}
 
-	if pod == nil || !validPod(pod) {
-		return nil, nil
+	if described != "" {
+		return described
+	}
+
+	return fmt.Sprintf("Pod is %s", strings.ToLower(string(pod.Status.Phase)))
+}
+
+func containerState(pod *corev1.Pod, container string) (corev1.ContainerState, bool) {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			if status.Name == container {
+				return status.State, true
+			}
+		}
 	}
 
-	return pod, nil
+	return corev1.ContainerState{}, false
+}
+
+func describeContainerState(state corev1.ContainerState) string {
+	switch {
+	case state.Running != nil:
+		return "running"
+
+	case state.Terminated != nil:
+		return fmt.Sprintf("terminated (%s, exit code %d)", state.Terminated.Reason, state.Terminated.ExitCode)
+
+	case state.Waiting != nil:
+		switch state.Waiting.Reason {
+		case "":
+			return "waiting"
+		case "ContainerCreating":
+			return "being created (pulling image)"
+		}
+
+		if state.Waiting.Message != "" {
+			return fmt.Sprintf("waiting (%s: %s)", state.Waiting.Reason, state.Waiting.Message)
+		}
+
+		return fmt.Sprintf("waiting (%s)", state.Waiting.Reason)
+
+	default:
+		return "in an unknown state"
+	}
 }
@@ -0,0 +1,205 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package prow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var ProwJobResource = schema.GroupVersionResource{
+	Group:    "prow.k8s.io",
+	Version:  "v1",
+	Resource: "prowjobs",
+}
+
+type ProwJobState string
+
+const (
+	ProwJobStateTriggered  ProwJobState = "triggered"
+	ProwJobStateScheduling ProwJobState = "scheduling"
+	ProwJobStatePending    ProwJobState = "pending"
+	ProwJobStateSuccess    ProwJobState = "success"
+	ProwJobStateFailure    ProwJobState = "failure"
+	ProwJobStateAborted    ProwJobState = "aborted"
+	ProwJobStateError      ProwJobState = "error"
+)
+
+// ProwJob is the subset of Prow's ProwJob type that dj cares about. Prow is
+// deliberately not imported as a dependency, as it is huge.
+type ProwJob struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ProwJobSpec   `json:"spec,omitempty"`
+	Status ProwJobStatus `json:"status,omitempty"`
+}
+
+type ProwJobSpec struct {
+	Type      string `json:"type,omitempty"`
+	Job       string `json:"job,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Refs      *Refs  `json:"refs,omitempty"`
+}
+
+type Refs struct {
+	Org     string `json:"org"`
+	Repo    string `json:"repo"`
+	BaseRef string `json:"base_ref,omitempty"`
+	Pulls   []Pull `json:"pulls,omitempty"`
+}
+
+type Pull struct {
+	Number int    `json:"number"`
+	Author string `json:"author"`
+	SHA    string `json:"sha"`
+}
+
+type ProwJobStatus struct {
+	StartTime      metav1.Time  `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	State          ProwJobState `json:"state,omitempty"`
+	Description    string       `json:"description,omitempty"`
+	URL            string       `json:"url,omitempty"`
+	PodName        string       `json:"pod_name,omitempty"`
+	BuildID        string       `json:"build_id,omitempty"`
+}
+
+// IsFinished returns true if the job has reached a final state.
+func (pj *ProwJob) IsFinished() bool {
+	switch pj.Status.State {
+	case ProwJobStateSuccess, ProwJobStateFailure, ProwJobStateAborted, ProwJobStateError:
+		return true
+	default:
+		return false
+	}
+}
+
+// RefsString returns a short, human readable description of the job's refs.
+func (pj *ProwJob) RefsString() string {
+	refs := pj.Spec.Refs
+	if refs == nil {
+		return ""
+	}
+
+	var pulls []string
+	for _, pull := range refs.Pulls {
+		pulls = append(pulls, fmt.Sprintf("#%d (%s)", pull.Number, pull.Author))
+	}
+
+	if len(pulls) == 0 {
+		return fmt.Sprintf("%s/%s@%s", refs.Org, refs.Repo, refs.BaseRef)
+	}
+
+	return fmt.Sprintf("%s/%s%s", refs.Org, refs.Repo, strings.Join(pulls, ","))
+}
+
+// IsProwJobsUnavailable returns true if the error indicates that ProwJobs
+// cannot be accessed at all (i.e. because the CRD is not installed in the
+// cluster or the user has no permissions), in which case dj continues to
+// work with just Pods.
+func IsProwJobsUnavailable(err error) bool {
+	return meta.IsNoMatchError(err) || apierrors.IsNotFound(err) || apierrors.IsForbidden(err)
+}
+
+// FindProwJob returns the most recent ProwJob matching the identifier. If
+// no ProwJob matches, nil is returned.
+func (i *PodIdentifier) FindProwJob(ctx context.Context, client dynamic.Interface, namespace string) (*ProwJob, error) {
+	// ProwJobs are named after their job ID
+	if i.JobID != "" {
+		obj, err := client.Resource(ProwJobResource).Namespace(namespace).Get(ctx, i.JobID, metav1.GetOptions{})
+		if err != nil {
+			// a missing CRD also yields a NotFound error
+			if apierrors.IsNotFound(err) && isObjectNotFound(err, i.JobID) {
+				return nil, nil
+			}
+
+			return nil, err
+		}
+
+		return convertProwJob(obj)
+	}
+
+	// ProwJobs carry the same labels as their Pods, except for the ID
+	selector := (&PodIdentifier{BuildID: i.BuildID, JobName: i.JobName, Org: i.Org, Repo: i.Repo, Pull: i.Pull}).LabelSelector()
+
+	// never list all ProwJobs in the namespace, this is expensive on real
+	// Prow clusters
+	if selector == "" {
+		return nil, nil
+	}
+
+	list, err := client.Resource(ProwJobResource).Namespace(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []ProwJob
+	for idx := range list.Items {
+		pj, err := convertProwJob(&list.Items[idx])
+		if err != nil {
+			return nil, err
+		}
+
+		if i.matchesProwJob(pj) {
+			candidates = append(candidates, *pj)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	// newest first
+	slices.SortFunc(candidates, func(a, b ProwJob) int {
+		return b.CreationTimestamp.Compare(a.CreationTimestamp.Time)
+	})
+
+	return &candidates[0], nil
+}
+
+func (i *PodIdentifier) matchesProwJob(pj *ProwJob) bool {
+	if i.BuildID != "" && pj.Status.BuildID != i.BuildID && pj.Labels[BuildIDLabel] != i.BuildID {
+		return false
+	}
+
+	if i.JobName != "" && pj.Spec.Job != i.JobName {
+		return false
+	}
+
+	return true
+}
+
+func isObjectNotFound(err error, name string) bool {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) {
+		return false
+	}
+
+	details := status.Status().Details
+
+	return details != nil && details.Name == name
+}
+
+func convertProwJob(obj *unstructured.Unstructured) (*ProwJob, error) {
+	pj := &ProwJob{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, pj); err != nil {
+		return nil, fmt.Errorf("failed to parse ProwJob: %w", err)
+	}
+
+	return pj, nil
+}
@@ -0,0 +1,191 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package prow
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+func looksLikeURL(arg string) bool {
+	for _, scheme := range []string{"http://", "https://", "gs://", "s3://"} {
+		if strings.HasPrefix(arg, scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseURL extracts job information from the many URLs Prow and its storage
+// backends use, for example
+//
+//   - https://prow.example.com/view/gs/bucket/pr-logs/pull/org_repo/123/job-name/1789…
+//   - https://prow.example.com/view/gs/bucket/logs/job-name/1789…
+//   - https://prow.example.com/log?job=job-name&id=1789…
+//   - https://prow.example.com/prowjob?prowjob=2d5a1d8e-…
+//   - https://gcsweb.example.com/gcs/bucket/pr-logs/pull/org_repo/123/job-name/1789…/prowjob.json
+//   - gs://bucket/pr-logs/pull/org_repo/123/job-name/1789…/
+func parseURL(arg string) (*PodIdentifier, error) {
+	u, err := url.Parse(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	query := u.Query()
+
+	// Deck's prowjob.yaml links
+	if prowjob := query.Get("prowjob"); prowjob != "" {
+		parsed, err := uuid.Parse(prowjob)
+		if err != nil {
+			return nil, fmt.Errorf("URL contains invalid job ID %q: %w", prowjob, err)
+		}
+
+		return &PodIdentifier{JobID: parsed.String()}, nil
+	}
+
+	// Deck's raw log links
+	if job, id := query.Get("job"), query.Get("id"); job != "" && id != "" {
+		ident := &PodIdentifier{JobName: job, BuildID: id}
+		if err := ident.validate(); err != nil {
+			return nil, err
+		}
+
+		return ident, nil
+	}
+
+	// everything else is a storage path; for gs:// URLs the bucket is
+	// the host, so it doesn't need to be considered here
+	segments := strings.FieldsFunc(u.Path, func(r rune) bool { return r == '/' })
+
+	ident, err := parseStoragePath(segments)
+	if err != nil {
+		return nil, err
+	}
+
+	if ident == nil {
+		return nil, fmt.Errorf("%q is not a recognized Prow URL", arg)
+	}
+
+	return ident, nil
+}
+
+// parseStoragePath looks for the well-known Prow job storage layouts in the
+// given path segments:
+//
+//   - pr-logs/pull/[ORG_REPO/]PR/JOB/BUILD (presubmits)
+//   - pr-logs/directory/JOB/BUILD (presubmit symlinks)
+//   - logs/JOB/BUILD (periodics and postsubmits)
+func parseStoragePath(segments []string) (*PodIdentifier, error) {
+	var lastErr error
+
+	// bucket names or URL prefixes could also contain "logs" elements, so
+	// every candidate is tried until one yields a valid identifier
+	for idx, segment := range segments {
+		var (
+			ident *PodIdentifier
+			err   error
+			rest  = segments[idx+1:]
+		)
+
+		switch {
+		case segment == "pr-logs" && len(rest) >= 4 && rest[0] == "pull":
+			ident, err = parsePullPath(rest[1:])
+		case segment == "pr-logs" && len(rest) >= 3 && rest[0] == "directory":
+			ident, err = newIdentFromPath(rest[1], rest[2])
+		case segment == "logs" && len(rest) >= 2:
+			ident, err = newIdentFromPath(rest[0], rest[1])
+		default:
+			continue
+		}
+
+		if err == nil {
+			return ident, nil
+		}
+
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+func parsePullPath(segments []string) (*PodIdentifier, error) {
+	ident := &PodIdentifier{}
+
+	// the ORG_REPO element is omitted for the default repository of a Prow instance
+	if _, err := strconv.ParseUint(segments[0], 10, 32); err != nil {
+		// GitHub organizations cannot contain underscores, repositories can
+		org, repo, ok := strings.Cut(segments[0], "_")
+		if !ok {
+			return nil, fmt.Errorf("%q is not a valid ORG_REPO path element", segments[0])
+		}
+
+		ident.Org = org
+		ident.Repo = repo
+		segments = segments[1:]
+	}
+
+	if len(segments) < 3 {
+		return nil, fmt.Errorf("incomplete presubmit path %q", strings.Join(segments, "/"))
+	}
+
+	ident.Pull = segments[0]
+	ident.JobName = segments[1]
+	ident.BuildID = trimBuildID(segments[2])
+
+	if err := ident.validate(); err != nil {
+		return nil, err
+	}
+
+	return ident, nil
+}
+
+func newIdentFromPath(jobName string, buildID string) (*PodIdentifier, error) {
+	ident := &PodIdentifier{
+		JobName: jobName,
+		BuildID: trimBuildID(buildID),
+	}
+
+	if err := ident.validate(); err != nil {
+		return nil, err
+	}
+
+	return ident, nil
+}
+
+// trimBuildID removes the file extension from symlinked build IDs
+// (pr-logs/directory/JOB/BUILD.txt).
+func trimBuildID(buildID string) string {
+	return strings.TrimSuffix(buildID, ".txt")
+}
+
+func (i *PodIdentifier) validate() error {
+	if i.BuildID != "" {
+		if _, err := strconv.ParseInt(i.BuildID, 10, 64); err != nil {
+			return fmt.Errorf("%q is not a valid 64-bit build ID", i.BuildID)
+		}
+	}
+
+	if i.JobName != "" && !jobNameRegex.MatchString(i.JobName) {
+		return fmt.Errorf("%q is not a valid job name", i.JobName)
+	}
+
+	if i.Pull != "" {
+		if _, err := strconv.ParseUint(i.Pull, 10, 32); err != nil {
+			return fmt.Errorf("%q is not a valid PR number", i.Pull)
+		}
+	}
+
+	for _, s := range []string{i.Org, i.Repo} {
+		if s != "" && !orgRepoRegex.MatchString(s) {
+			return fmt.Errorf("%q is not a valid repository", fmt.Sprintf("%s/%s", i.Org, i.Repo))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package script
+
+// The scripts used by the dump collectors. Scripts created by newKindScript
+// require the ClusterName parameter and have $KUBECONFIG pointing to the kind
+// cluster. DumpKKPLogs additionally requires the KKPNamespace parameter.
+var (
+	DumpProcesses = New("dump-processes", `ps auxf 2>/dev/null || ps`)
+
+	DumpDisk = New("dump-disk", `df -h`)
+
+	DumpKindClusters = New("dump-kind-clusters", `dj_timeout {{ .Timeout }} kind get clusters`)
+
+	DumpKindLogs = newKindScript("dump-kind-logs", `
+logDir="$(mktemp -d)"
+dj_timeout {{ .Timeout }} kind export logs --name {{ .ClusterName }} "$logDir" >/dev/null 2>&1
+tar czf - -C "$logDir" .
+rm -rf "$logDir"
+`)
+
+	DumpKindNodes = newKindScript("dump-kind-nodes", `dj_timeout {{ .Timeout }} kubectl get nodes --output yaml`)
+
+	DumpKindResources = newKindScript("dump-kind-resources", `dj_timeout {{ .Timeout }} kubectl get all --all-namespaces --output wide`)
+
+	DumpKindEvents = newKindScript("dump-kind-events", `dj_timeout {{ .Timeout }} kubectl get events --all-namespaces --sort-by .lastTimestamp`)
+
+	DumpKKPObjects = newKindScript("dump-kkp-objects", `
+for kind in kubermaticconfigurations seeds clusters; do
+  echo "---"
+  echo "# $kind"
+  dj_timeout {{ .Timeout }} kubectl get "$kind" --all-namespaces --output yaml 2>&1 || true
+done
+`)
+
+	DumpKKPLogs = newKindScript("dump-kkp-logs", `
+for pod in $(dj_timeout {{ .Timeout }} kubectl --namespace {{ .KKPNamespace }} get pods --output name); do
+  echo "=== $pod"
+  dj_timeout {{ .Timeout }} kubectl --namespace {{ .KKPNamespace }} logs --all-containers --prefix --tail 5000 "$pod" 2>&1 || true
+done
+`)
+)
+
+func newKindScript(name string, body string) *Script {
+	return New(name, `{{ template "kind-kubeconfig" . }}`+"\n"+body)
+}
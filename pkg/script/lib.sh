@@ -0,0 +1,32 @@
+{{ define "preamble" -}}
+# dj script library v{{ version }}
+set -euo pipefail
+
+# dj_timeout SECONDS COMMAND... runs a command and aborts it after the given
+# number of seconds (if the timeout binary is available); 0 disables the timeout.
+dj_timeout() {
+  local seconds="$1"
+  shift
+
+  if [ "$seconds" -gt 0 ] && command -v timeout >/dev/null 2>&1; then
+    timeout "$seconds" "$@"
+  else
+    "$@"
+  fi
+}
+{{- end }}
+
+{{ define "stop-pidfile" -}}
+pidFile={{ .PIDFile }}
+mkdir -p "$(dirname "$pidFile")"
+
+if [ -f "$pidFile" ]; then
+  pkill -F "$pidFile" || true
+  rm -f "$pidFile"
+fi
+{{- end }}
+
+{{ define "kind-kubeconfig" -}}
+export KUBECONFIG="$(mktemp)"
+dj_timeout {{ .Timeout }} kind get kubeconfig --name {{ .ClusterName }} > "$KUBECONFIG"
+{{- end }}
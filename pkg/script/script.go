@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+// Package script renders the bash scripts that dj runs inside Prow job Pods.
+// Every script is a text/template that can use the shared library in lib.sh
+// and is prefixed with a common preamble. All string parameters are
+// shell-quoted before they are passed to the template.
+package script
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"maps"
+	"math"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Version is increased whenever the library changes incompatibly. It is
+// part of all paths used by scripts, so that different dj versions do not
+// interfere with each other.
+const Version = 1
+
+//go:embed lib.sh
+var library string
+
+// Params are the parameters for rendering a script.
+type Params map[string]any
+
+// Script is a bash script template.
+type Script struct {
+	name string
+	tpl  *template.Template
+}
+
+// New parses a script. As scripts are static, parsing errors lead to a panic.
+func New(name string, body string) *Script {
+	funcs := template.FuncMap{
+		"version": func() int { return Version },
+	}
+
+	tpl := template.Must(template.New(name).Funcs(funcs).Option("missingkey=error").Parse(library))
+	template.Must(tpl.New("main").Parse(strings.TrimSpace(body)))
+
+	return &Script{
+		name: name,
+		tpl:  tpl,
+	}
+}
+
+func (s *Script) Name() string {
+	return s.name
+}
+
+// Render returns the script with all parameters filled in.
+func (s *Script) Render(params Params) (string, error) {
+	escaped := Params{}
+
+	for key, value := range params {
+		switch v := value.(type) {
+		case string:
+			escaped[key] = Quote(v)
+		case int, int64, bool:
+			escaped[key] = v
+		default:
+			return "", fmt.Errorf("unsupported type %T for script parameter %q", value, key)
+		}
+	}
+
+	var buf strings.Builder
+
+	for _, name := range []string{"preamble", "main"} {
+		if err := s.tpl.ExecuteTemplate(&buf, name, escaped); err != nil {
+			return "", fmt.Errorf("failed to render %s script: %w", s.name, err)
+		}
+
+		buf.WriteString("\n")
+	}
+
+	return buf.String(), nil
+}
+
+// Command returns the command to run the rendered script with bash. If the
+// context has a deadline, the remaining time (in whole seconds) is available
+// as the Timeout parameter, so that long-running commands can be wrapped in
+// dj_timeout and do not outlive the caller; without deadline Timeout is 0.
+func (s *Script) Command(ctx context.Context, params Params) ([]string, error) {
+	withTimeout := Params{
+		"Timeout": 0,
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		withTimeout["Timeout"] = max(1, int(math.Ceil(time.Until(deadline).Seconds())))
+	}
+
+	maps.Copy(withTimeout, params)
+
+	rendered, err := s.Render(withTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{"bash", "-c", rendered}, nil
+}
+
+// Quote quotes a string for safe use in a shell script.
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// PIDFile returns the path for a PID file inside the container.
+func PIDFile(name string) string {
+	return fmt.Sprintf("/tmp/dj-v%d/%s.pid", Version, name)
+}
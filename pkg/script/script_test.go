@@ -0,0 +1,502 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package script
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hostile is used for all string parameters, so that broken quoting either
+// leads to a syntax error, a mangled argument or a file named "pwned".
+const hostile = `it's a $(touch pwned) "trap" ` + "`touch pwned`"
+
+// stubKind records its arguments (one <arg> per argument) and pretends to
+// manage a single kind cluster.
+const stubKind = `#!/usr/bin/env bash
+printf 'kind'  >> "$DJ_STUB_LOG"; printf ' <%s>' "$@" >> "$DJ_STUB_LOG"; echo >> "$DJ_STUB_LOG"
+
+case "$1 $2" in
+  "get clusters")   echo kind ;;
+  "get kubeconfig") echo "apiVersion: v1" ;;
+  "export logs")    for last; do :; done; echo "kind log" > "$last/kind.log" ;;
+esac
+
+exit "${DJ_STUB_KIND_EXIT:-0}"
+`
+
+// stubKubectl records its arguments and requires a kubeconfig from stubKind.
+const stubKubectl = `#!/usr/bin/env bash
+grep -q apiVersion "$KUBECONFIG" || exit 99
+
+printf 'kubectl' >> "$DJ_STUB_LOG"; printf ' <%s>' "$@" >> "$DJ_STUB_LOG"; echo >> "$DJ_STUB_LOG"
+
+if [ -n "${DJ_STUB_SLEEP:-}" ]; then
+  sleep "$DJ_STUB_SLEEP"
+fi
+
+case "$*" in
+  *"get pods --output name"*) echo "pod/kubermatic-operator-0" ;;
+esac
+`
+
+type scriptTestcase struct {
+	name   string
+	script *Script
+	params func(dir string) Params
+	// expected prefixes of the lines in the stubs' log
+	calls []string
+}
+
+func testcases() []scriptTestcase {
+	kindParams := func(string) Params {
+		return Params{"ClusterName": hostile}
+	}
+
+	kubeconfigCall := "kind <get> <kubeconfig> <--name> <" + hostile + ">"
+
+	return []scriptTestcase{
+		{
+			name:   "kind-cluster-proxy",
+			script: KindClusterProxy,
+			params: func(dir string) Params {
+				return Params{
+					"ClusterName": hostile,
+					"Port":        12345,
+					"PIDFile":     filepath.Join(dir, hostile, "proxy.pid"),
+				}
+			},
+			calls: []string{kubeconfigCall, "kubectl <proxy> <--port=12345>"},
+		},
+		{
+			name:   "stop-pidfile",
+			script: StopPIDFile,
+			params: func(dir string) Params {
+				return Params{"PIDFile": filepath.Join(dir, hostile, "missing.pid")}
+			},
+		},
+		{
+			name:   "free-port",
+			script: FreePort,
+			params: func(string) Params {
+				return Params{"Min": 20000, "Max": 30000}
+			},
+		},
+		{
+			name:   "dump-processes",
+			script: DumpProcesses,
+			params: func(string) Params { return nil },
+		},
+		{
+			name:   "dump-disk",
+			script: DumpDisk,
+			params: func(string) Params { return nil },
+		},
+		{
+			name:   "dump-kind-clusters",
+			script: DumpKindClusters,
+			params: func(string) Params { return nil },
+			calls:  []string{"kind <get> <clusters>"},
+		},
+		{
+			name:   "dump-kind-logs",
+			script: DumpKindLogs,
+			params: kindParams,
+			calls:  []string{kubeconfigCall, "kind <export> <logs> <--name> <" + hostile + "> <"},
+		},
+		{
+			name:   "dump-kind-nodes",
+			script: DumpKindNodes,
+			params: kindParams,
+			calls:  []string{kubeconfigCall, "kubectl <get> <nodes> <--output> <yaml>"},
+		},
+		{
+			name:   "dump-kind-resources",
+			script: DumpKindResources,
+			params: kindParams,
+			calls:  []string{kubeconfigCall, "kubectl <get> <all> <--all-namespaces> <--output> <wide>"},
+		},
+		{
+			name:   "dump-kind-events",
+			script: DumpKindEvents,
+			params: kindParams,
+			calls:  []string{kubeconfigCall, "kubectl <get> <events> <--all-namespaces> <--sort-by> <.lastTimestamp>"},
+		},
+		{
+			name:   "dump-kkp-objects",
+			script: DumpKKPObjects,
+			params: kindParams,
+			calls: []string{
+				kubeconfigCall,
+				"kubectl <get> <kubermaticconfigurations> <--all-namespaces> <--output> <yaml>",
+				"kubectl <get> <seeds> <--all-namespaces> <--output> <yaml>",
+				"kubectl <get> <clusters> <--all-namespaces> <--output> <yaml>",
+			},
+		},
+		{
+			name:   "dump-kkp-logs",
+			script: DumpKKPLogs,
+			params: func(string) Params {
+				return Params{"ClusterName": hostile, "KKPNamespace": hostile}
+			},
+			calls: []string{
+				kubeconfigCall,
+				"kubectl <--namespace> <" + hostile + "> <get> <pods> <--output> <name>",
+				"kubectl <--namespace> <" + hostile + "> <logs> <--all-containers> <--prefix> <--tail> <5000> <pod/kubermatic-operator-0>",
+			},
+		},
+	}
+}
+
+type stubEnv struct {
+	dir string
+	log string
+	env []string
+}
+
+func setupStubs(t *testing.T) *stubEnv {
+	t.Helper()
+
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not available")
+	}
+
+	dir := t.TempDir()
+	binDir := filepath.Join(dir, "bin")
+
+	if err := os.Mkdir(binDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{"kind": stubKind, "kubectl": stubKubectl} {
+		if err := os.WriteFile(filepath.Join(binDir, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	log := filepath.Join(dir, "calls.log")
+
+	return &stubEnv{
+		dir: dir,
+		log: log,
+		env: append(os.Environ(),
+			"PATH="+binDir+string(os.PathListSeparator)+os.Getenv("PATH"),
+			"DJ_STUB_LOG="+log,
+		),
+	}
+}
+
+// run executes the rendered script inside the stub environment and returns
+// its combined output and exit code.
+func (s *stubEnv) run(t *testing.T, rendered string, env ...string) (string, int) {
+	t.Helper()
+
+	var output bytes.Buffer
+
+	cmd := exec.Command("bash", "-c", rendered)
+	cmd.Dir = s.dir
+	cmd.Env = append(s.env, env...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return output.String(), exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatalf("Failed to run script: %v", err)
+	}
+
+	return output.String(), 0
+}
+
+func (s *stubEnv) calls(t *testing.T) []string {
+	t.Helper()
+
+	content, err := os.ReadFile(s.log)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+func (s *stubEnv) assertNotPwned(t *testing.T) {
+	t.Helper()
+
+	if _, err := os.Stat(filepath.Join(s.dir, "pwned")); err == nil {
+		t.Fatal("Parameter was not quoted properly, command substitution was executed.")
+	}
+}
+
+func withTimeout(params Params, timeout int) Params {
+	result := Params{"Timeout": timeout}
+	for k, v := range params {
+		result[k] = v
+	}
+
+	return result
+}
+
+func TestScripts(t *testing.T) {
+	for _, tc := range testcases() {
+		for _, timeout := range []int{0, 30} {
+			t.Run(tc.name+"/timeout="+strconv.Itoa(timeout), func(t *testing.T) {
+				stubs := setupStubs(t)
+
+				rendered, err := tc.script.Render(withTimeout(tc.params(stubs.dir), timeout))
+				if err != nil {
+					t.Fatalf("Failed to render script: %v", err)
+				}
+
+				if output, err := exec.Command("bash", "-n", "-c", rendered).CombinedOutput(); err != nil {
+					t.Fatalf("Script has syntax errors: %v\n%s\n\n%s", err, output, rendered)
+				}
+
+				output, exitCode := stubs.run(t, rendered)
+				if exitCode != 0 {
+					t.Fatalf("Script failed with exit code %d:\n%s\n\n%s", exitCode, output, rendered)
+				}
+
+				stubs.assertNotPwned(t)
+
+				calls := stubs.calls(t)
+				if len(calls) != len(tc.calls) {
+					t.Fatalf("Expected calls\n  %s\nbut got\n  %s", strings.Join(tc.calls, "\n  "), strings.Join(calls, "\n  "))
+				}
+
+				for i, expected := range tc.calls {
+					if !strings.HasPrefix(calls[i], expected) {
+						t.Errorf("Expected call #%d to be\n  %s\nbut got\n  %s", i, expected, calls[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestScriptFailuresArePropagated(t *testing.T) {
+	stubs := setupStubs(t)
+
+	rendered, err := DumpKindClusters.Render(Params{"Timeout": 0})
+	if err != nil {
+		t.Fatalf("Failed to render script: %v", err)
+	}
+
+	if _, exitCode := stubs.run(t, rendered, "DJ_STUB_KIND_EXIT=3"); exitCode != 3 {
+		t.Fatalf("Expected exit code 3, got %d.", exitCode)
+	}
+
+	// failing to get the kubeconfig must abort kind scripts
+	rendered, err = DumpKindNodes.Render(Params{"Timeout": 0, "ClusterName": hostile})
+	if err != nil {
+		t.Fatalf("Failed to render script: %v", err)
+	}
+
+	if _, exitCode := stubs.run(t, rendered, "DJ_STUB_KIND_EXIT=3"); exitCode != 3 {
+		t.Fatalf("Expected exit code 3, got %d.", exitCode)
+	}
+
+	for _, call := range stubs.calls(t) {
+		if strings.HasPrefix(call, "kubectl") {
+			t.Fatalf("kubectl should not have been called, but got %q.", call)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	if _, err := exec.LookPath("timeout"); err != nil {
+		t.Skip("timeout is not available")
+	}
+
+	stubs := setupStubs(t)
+
+	rendered, err := DumpKindNodes.Render(Params{"Timeout": 1, "ClusterName": "kind"})
+	if err != nil {
+		t.Fatalf("Failed to render script: %v", err)
+	}
+
+	start := time.Now()
+
+	if _, exitCode := stubs.run(t, rendered, "DJ_STUB_SLEEP=10"); exitCode != 124 {
+		t.Fatalf("Expected exit code 124, got %d.", exitCode)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Script was not aborted after 1s, but took %v.", elapsed)
+	}
+
+	// without a timeout, the command must run to completion
+	rendered, err = DumpKindNodes.Render(Params{"Timeout": 0, "ClusterName": "kind"})
+	if err != nil {
+		t.Fatalf("Failed to render script: %v", err)
+	}
+
+	if output, exitCode := stubs.run(t, rendered, "DJ_STUB_SLEEP=2"); exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d:\n%s", exitCode, output)
+	}
+}
+
+func TestKindClusterProxyManagesPIDFile(t *testing.T) {
+	stubs := setupStubs(t)
+	pidFile := filepath.Join(stubs.dir, hostile, "proxy.pid")
+
+	rendered, err := KindClusterProxy.Render(Params{"Timeout": 0, "ClusterName": "kind", "Port": 8001, "PIDFile": pidFile})
+	if err != nil {
+		t.Fatalf("Failed to render script: %v", err)
+	}
+
+	var output bytes.Buffer
+
+	cmd := exec.Command("bash", "-c", rendered)
+	cmd.Dir = stubs.dir
+	cmd.Env = append(stubs.env, "DJ_STUB_SLEEP=2")
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to run script: %v", err)
+	}
+
+	// the PID file must exist while kubectl proxy is running
+	var content []byte
+	for start := time.Now(); time.Since(start) < 2*time.Second; time.Sleep(50 * time.Millisecond) {
+		if content, err = os.ReadFile(pidFile); err == nil && len(content) > 0 {
+			break
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("Script failed: %v\n%s", err, output.String())
+	}
+
+	if _, err := strconv.Atoi(strings.TrimSpace(string(content))); err != nil {
+		t.Fatalf("PID file was not written while the proxy was running: %q", content)
+	}
+
+	// and must be removed once it has stopped
+	if _, err := os.Stat(pidFile); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("PID file was not removed: %v", err)
+	}
+}
+
+func TestStopPIDFile(t *testing.T) {
+	if _, err := exec.LookPath("pkill"); err != nil {
+		t.Skip("pkill is not available")
+	}
+
+	stubs := setupStubs(t)
+	pidFile := filepath.Join(stubs.dir, hostile, "sleep.pid")
+
+	sleep := exec.Command("sleep", "60")
+	if err := sleep.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sleep.Process.Kill()
+
+	if err := os.MkdirAll(filepath.Dir(pidFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(sleep.Process.Pid)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rendered, err := StopPIDFile.Render(Params{"PIDFile": pidFile})
+	if err != nil {
+		t.Fatalf("Failed to render script: %v", err)
+	}
+
+	if output, exitCode := stubs.run(t, rendered); exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d:\n%s", exitCode, output)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- sleep.Wait() }()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Process was not stopped.")
+	}
+
+	if _, err := os.Stat(pidFile); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("PID file was not removed: %v", err)
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	command, err := DumpKindClusters.Command(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+
+	if !strings.Contains(command[2], "dj_timeout 0 kind get clusters") {
+		t.Errorf("Expected Timeout=0 without deadline, got:\n%s", command[2])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	defer cancel()
+
+	command, err = DumpKindClusters.Command(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+
+	if !strings.Contains(command[2], "dj_timeout 90 kind get clusters") {
+		t.Errorf("Expected Timeout=90 with deadline, got:\n%s", command[2])
+	}
+}
+
+func TestFreePort(t *testing.T) {
+	if _, err := os.Stat("/proc/net/tcp"); err != nil {
+		t.Skip("/proc/net/tcp is not available")
+	}
+
+	stubs := setupStubs(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	used := listener.Addr().(*net.TCPAddr).Port
+
+	rendered, err := FreePort.Render(Params{"Min": used, "Max": used + 1})
+	if err != nil {
+		t.Fatalf("Failed to render script: %v", err)
+	}
+
+	if output, exitCode := stubs.run(t, rendered); exitCode == 0 {
+		t.Fatalf("Expected port %d to be detected as in use, but got %q.", used, output)
+	}
+
+	rendered, err = FreePort.Render(Params{"Min": 20000, "Max": 30000})
+	if err != nil {
+		t.Fatalf("Failed to render script: %v", err)
+	}
+
+	output, exitCode := stubs.run(t, rendered)
+	if exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d:\n%s", exitCode, output)
+	}
+
+	port, err := strconv.Atoi(strings.TrimSpace(output))
+	if err != nil || port < 20000 || port >= 30000 {
+		t.Fatalf("Expected a port in [20000, 30000), got %q.", output)
+	}
+}
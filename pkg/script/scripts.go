@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package script
+
+// KindClusterProxy runs kubectl-proxy for a kind cluster in the foreground.
+// Parameters: ClusterName, Port, PIDFile.
+var KindClusterProxy = New("kind-cluster-proxy", `
+# enable job control
+set -m
+
+{{ template "stop-pidfile" . }}
+trap 'rm -f "$pidFile"' EXIT
+
+{{ template "kind-kubeconfig" . }}
+
+kubectl proxy --port={{ .Port }} >/dev/null &
+echo $! > "$pidFile"
+fg
+`)
+
+// StopPIDFile stops the process whose PID is stored in a PID file, if any.
+// Parameters: PIDFile.
+var StopPIDFile = New("stop-pidfile", `{{ template "stop-pidfile" . }}`)
+
+// FreePort prints a random port in [Min, Max) that nothing in the container
+// is listening on.
+// Parameters: Min, Max.
+var FreePort = New("free-port", `
+# ports in LISTEN state, as uppercase hex
+used="$(awk 'FNR > 1 && $4 == "0A" { split($2, addr, ":"); print addr[2] }' /proc/net/tcp /proc/net/tcp6 2>/dev/null || true)"
+
+for ((i = 0; i < 100; i++)); do
+  port=$(({{ .Min }} + RANDOM % ({{ .Max }} - {{ .Min }})))
+
+  if ! grep -qxF "$(printf '%04X' "$port")" <<< "$used"; then
+    echo "$port"
+    exit 0
+  fi
+done
+
+echo "no free port found" >&2
+exit 1
+`)
@@ -9,8 +9,6 @@ import (
 	"errors"
 	"io"
 
-	"go.xrstf.de/dj/pkg/prow"
-
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -20,6 +18,27 @@ import (
 )
 
 func RunCommand(ctx context.Context, clientset *kubernetes.Clientset, restConfig *rest.Config, pod *corev1.Pod, container string, command []string, stdin io.Reader) (string, error) {
+	var (
+		stdout bytes.Buffer
+		stderr bytes.Buffer
+	)
+
+	err := RunCommandWithStreams(ctx, clientset, restConfig, pod, container, command, stdin, &stdout, &stderr)
+	if err != nil {
+		// commands can fail without any output
+		if stderr.Len() == 0 {
+			return stdout.String(), err
+		}
+
+		return stdout.String(), errors.New(stderr.String())
+	}
+
+	return stdout.String(), nil
+}
+
+// RunCommandWithStreams runs a command without a TTY, so stdout and stderr
+// are kept separate and binary data is passed through unmodified.
+func RunCommandWithStreams(ctx context.Context, clientset *kubernetes.Clientset, restConfig *rest.Config, pod *corev1.Pod, container string, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
 	request := clientset.CoreV1().RESTClient().
 		Post().
 		Resource("pods").
@@ -31,32 +50,22 @@ func RunCommand(ctx context.Context, clientset *kubernetes.Clientset, restConfig
 		Container: container,
 		Command:   command,
 		Stdin:     stdin != nil,
-		Stdout:    true,
-		Stderr:    true,
+		Stdout:    stdout != nil,
+		Stderr:    stderr != nil,
 	}
 
 	request.VersionedParams(option, scheme.ParameterCodec)
 
 	exec, err := remotecommand.NewSPDYExecutor(restConfig, "POST", request.URL())
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	var (
-		stdout bytes.Buffer
-		stderr bytes.Buffer
-	)
-
-	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+	return exec.StreamWithContext(ctx, remotecommand.StreamOptions{
 		Stdin:  stdin,
-		Stdout: &stdout,
-		Stderr: &stderr,
+		Stdout: stdout,
+		Stderr: stderr,
 	})
-	if err != nil {
-		return stdout.String(), errors.New(stderr.String())
-	}
-
-	return stdout.String(), nil
 }
 
 func RunCommandWithTTY(ctx context.Context, clientset *kubernetes.Clientset, restConfig *rest.Config, pod *corev1.Pod, container string, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
@@ -81,7 +90,7 @@ func RunCommandWithTTY(ctx context.Context, clientset *kubernetes.Clientset, res
 			SubResource("exec")
 
 		option := &corev1.PodExecOptions{
-			Container: prow.TestContainerName,
+			Container: container,
 			Command:   command,
 			Stdin:     stdin != nil,
 			Stdout:    stdout != nil,
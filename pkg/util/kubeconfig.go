@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package util
+
+import (
+	"fmt"
+	"net/url"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// ProxyKubeconfig returns a kubeconfig that points to an unauthenticated
+// kubectl-proxy (or anything else) running at the given server URL.
+func ProxyKubeconfig(name string, server string) ([]byte, error) {
+	config := clientcmdapi.NewConfig()
+
+	config.Clusters[name] = &clientcmdapi.Cluster{
+		Server: server,
+	}
+
+	config.AuthInfos[name] = &clientcmdapi.AuthInfo{}
+
+	config.Contexts[name] = &clientcmdapi.Context{
+		Cluster:  name,
+		AuthInfo: name,
+	}
+
+	config.CurrentContext = name
+
+	return clientcmd.Write(*config)
+}
+
+// KubeconfigServer returns the server URL of the current context's cluster.
+func KubeconfigServer(kubeconfig []byte) (*url.URL, error) {
+	config, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster, err := currentCluster(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return url.Parse(cluster.Server)
+}
+
+// KubeconfigCA returns the CA bundle of the current context's cluster.
+func KubeconfigCA(kubeconfig []byte) ([]byte, error) {
+	config, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster, err := currentCluster(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return cluster.CertificateAuthorityData, nil
+}
+
+// RewriteKubeconfigServer replaces the server URL of the current context's cluster.
+func RewriteKubeconfigServer(kubeconfig []byte, server string) ([]byte, error) {
+	config, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster, err := currentCluster(config)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster.Server = server
+
+	return clientcmd.Write(*config)
+}
+
+func currentCluster(config *clientcmdapi.Config) (*clientcmdapi.Cluster, error) {
+	kubeContext, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig has no context %q", config.CurrentContext)
+	}
+
+	cluster, ok := config.Clusters[kubeContext.Cluster]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig has no cluster %q", kubeContext.Cluster)
+	}
+
+	return cluster, nil
+}
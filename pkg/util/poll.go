@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package util
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// DefaultBackoff is used when polling for resources inside a Prow job Pod.
+var DefaultBackoff = wait.Backoff{
+	Duration: 500 * time.Millisecond,
+	Factor:   1.5,
+	Jitter:   0.1,
+	Steps:    10,
+	Cap:      10 * time.Second,
+}
+
+// Poll calls condition until it returns true or an error, waiting with
+// exponential backoff between attempts. Unlike wait.ExponentialBackoffWithContext,
+// polling does not stop once the backoff reached its cap, but only when the
+// context is cancelled.
+func Poll(ctx context.Context, backoff wait.Backoff, condition wait.ConditionWithContextFunc) error {
+	delay := backoff.DelayFunc()
+
+	for {
+		done, err := condition(ctx)
+		if err != nil || done {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay()):
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// PortForward forwards localPort on the given local address to remotePort in the given Pod.
+// The function blocks until the context is cancelled. Once the local port accepts connections,
+// the actually used local port is sent to readyChan (this is useful when localPort is 0, in
+// which case a random free port is chosen).
+//
+// Whenever a connection to the remote port fails (e.g. because nothing is listening on it
+// yet), client-go tears down the entire SPDY connection. Unlike kubectl, which then simply
+// ends, this function transparently re-establishes the forwarding, so that clients can
+// retry their requests. Re-connecting is throttled using DefaultBackoff and if the forwarding
+// keeps failing right away, the function gives up after DefaultBackoff.Steps attempts.
+func PortForward(ctx context.Context, logger logrus.FieldLogger, clientset *kubernetes.Clientset, restConfig *rest.Config, pod *corev1.Pod, address string, localPort int, remotePort int, readyChan chan<- int) error {
+	request := clientset.CoreV1().RESTClient().
+		Post().
+		Resource("pods").
+		Name(pod.Name).
+		Namespace(pod.Namespace).
+		SubResource("portforward")
+
+	transport, upgrader, err := spdy.RoundTripperFor(restConfig)
+	if err != nil {
+		return err
+	}
+
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", request.URL())
+	ready := false
+	delay := DefaultBackoff.DelayFunc()
+	failures := 0
+
+	for {
+		fwReady := make(chan struct{})
+		ports := []string{fmt.Sprintf("%d:%d", localPort, remotePort)}
+
+		fw, err := portforward.NewOnAddresses(dialer, []string{address}, ports, ctx.Done(), fwReady, io.Discard, io.Discard)
+		if err != nil {
+			return err
+		}
+
+		fwErr := make(chan error, 1)
+		go func() {
+			fwErr <- fw.ForwardPorts()
+		}()
+
+		started := time.Now()
+
+		select {
+		case <-fwReady:
+			if !ready {
+				forwarded, err := fw.GetPorts()
+				if err != nil {
+					return err
+				}
+
+				// when re-establishing the forwarding, make sure to re-use the same local port
+				localPort = int(forwarded[0].Local)
+
+				readyChan <- localPort
+				ready = true
+			}
+
+		case err := <-fwErr:
+			if err == nil {
+				err = ctx.Err()
+			}
+			return fmt.Errorf("failed to forward port: %w", err)
+		}
+
+		err = <-fwErr
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil && !errors.Is(err, portforward.ErrLostConnectionToPod) {
+			return err
+		}
+
+		// a forwarding that was working for a while resets the backoff
+		if time.Since(started) > DefaultBackoff.Cap {
+			delay = DefaultBackoff.DelayFunc()
+			failures = 0
+		}
+
+		failures++
+		if failures > DefaultBackoff.Steps {
+			return fmt.Errorf("giving up after %d failed attempts: %w", failures, err)
+		}
+
+		wait := delay()
+		logger.WithField("delay", wait).Debug("Lost connection to Pod, re-establishing port-forwarding…")
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package util
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// PrefixWriter prepends a prefix to every line written to it. Only complete
+// lines are written to the underlying writer, so that multiple PrefixWriters
+// sharing the same mutex can write to the same output without interleaving
+// their lines. Call Flush to write a trailing incomplete line.
+type PrefixWriter struct {
+	out    io.Writer
+	mu     *sync.Mutex
+	prefix []byte
+	buf    []byte
+}
+
+func NewPrefixWriter(out io.Writer, mu *sync.Mutex, prefix string) *PrefixWriter {
+	return &PrefixWriter{
+		out:    out,
+		mu:     mu,
+		prefix: []byte(prefix),
+	}
+}
+
+func (w *PrefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	idx := bytes.LastIndexByte(w.buf, '\n')
+	if idx < 0 {
+		return len(p), nil
+	}
+
+	if err := w.writeLines(w.buf[:idx+1]); err != nil {
+		return 0, err
+	}
+
+	w.buf = append(w.buf[:0], w.buf[idx+1:]...)
+
+	return len(p), nil
+}
+
+func (w *PrefixWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	err := w.writeLines(append(w.buf, '\n'))
+	w.buf = w.buf[:0]
+
+	return err
+}
+
+func (w *PrefixWriter) writeLines(lines []byte) error {
+	var out bytes.Buffer
+
+	for len(lines) > 0 {
+		idx := bytes.IndexByte(lines, '\n')
+
+		out.Write(w.prefix)
+		out.Write(lines[:idx+1])
+
+		lines = lines[idx+1:]
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err := w.out.Write(out.Bytes())
+
+	return err
+}
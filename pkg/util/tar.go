@@ -0,0 +1,178 @@
+// SPDX-FileCopyrightText: 2024 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package util
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// TarSource is a local file or directory that is added to a tar archive
+// under the given name.
+type TarSource struct {
+	Path string
+	Name string
+}
+
+// WriteTar writes all sources (directories recursively) into a tar stream.
+func WriteTar(w io.Writer, sources []TarSource) error {
+	tw := tar.NewWriter(w)
+
+	for _, source := range sources {
+		err := filepath.WalkDir(source.Path, func(filename string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(source.Path, filename)
+			if err != nil {
+				return err
+			}
+
+			return writeTarEntry(tw, filename, path.Join(source.Name, filepath.ToSlash(rel)))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+func writeTarEntry(tw *tar.Writer, filename string, name string) error {
+	info, err := os.Lstat(filename)
+	if err != nil {
+		return err
+	}
+
+	var link string
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if link, err = os.Readlink(filename); err != nil {
+			return err
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(tw, f)
+
+	return err
+}
+
+// ExtractTar extracts a tar stream into the given directory. If rename is
+// given, the first path element of every entry is replaced with it, which
+// allows to extract a single file or directory under a new name.
+func ExtractTar(r io.Reader, dir string, rename string) error {
+	tr := tar.NewReader(r)
+
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		name, err := tarEntryName(header.Name, rename)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(name))
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+
+		case tar.TypeReg:
+			if err := extractFile(tr, target, header.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			// like kubectl cp, skip links that point outside of the destination,
+			// as later entries could otherwise be written through them
+			if _, err := tarEntryName(path.Join(path.Dir(name), header.Linkname), ""); err != nil || path.IsAbs(header.Linkname) {
+				continue
+			}
+
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+
+		default:
+			// devices, hardlinks etc. are not supported
+		}
+	}
+}
+
+func tarEntryName(name string, rename string) (string, error) {
+	name = path.Clean(name)
+
+	if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("refusing to extract %q outside of the destination", name)
+	}
+
+	if rename != "" {
+		_, rest, _ := strings.Cut(name, "/")
+		name = path.Join(rename, rest)
+	}
+
+	return name, nil
+}
+
+func extractFile(r io.Reader, target string, mode fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}